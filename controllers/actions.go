@@ -2,14 +2,18 @@
 package controllers
 
 import (
+	"errors"
+
 	"github.com/jinzhu/gorm"
 	"github.com/quorumsco/contacts/models"
 	"github.com/quorumsco/logs"
+	elastic "gopkg.in/olivere/elastic.v2"
 )
 
-// Action contains the action related methods and a gorm client
+// Action contains the action related methods, a gorm client and an elasticsearch client
 type Action struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Client *elastic.Client
 }
 
 // RetrieveCollection calls the ActionSQL Find method and returns the results via RPC
@@ -57,12 +61,110 @@ func (t *Action) Create(args models.ActionArgs, reply *models.ActionReply) error
 	}
 
 	ID := args.Action.ID
-	args.Action = &models.Action{ID: ID}
+	args.Action = &models.Action{ID: ID, GroupID: args.Action.GroupID}
+
+	if reply.Action, err = actionStore.First(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Update calls the ActionSQL Save method and returns the results via RPC
+func (t *Action) Update(args models.ActionArgs, reply *models.ActionReply) error {
+	var (
+		actionStore = models.ActionStore(t.DB)
+		err         error
+	)
+
+	if err = actionStore.Save(args.Action, args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	if reply.Action, err = actionStore.First(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Transition calls the ActionSQL Transition method and returns the action in its new status via RPC
+func (t *Action) Transition(args models.ActionArgs, reply *models.ActionReply) error {
+	var (
+		actionStore = models.ActionStore(t.DB)
+		err         error
+	)
+
+	if reply.Action, err = actionStore.Transition(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Targets returns via RPC the contacts of the group matching the search of an action
+func (t *Action) Targets(args models.ActionArgs, reply *models.ActionReply) error {
+	var (
+		actionStore = models.ActionStore(t.DB)
+		err         error
+	)
 
 	if reply.Action, err = actionStore.First(args); err != nil {
 		logs.Error(err)
 		return err
 	}
+	if reply.Action == nil {
+		return errors.New("targets: action not found")
+	}
+	if reply.Action.Search == nil {
+		return errors.New("targets: the action has no search")
+	}
+
+	if reply.ContactIDs, err = (&Search{Client: t.Client, DB: t.DB}).contactIDs(reply.Action.GroupID, reply.Action.Search); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Matrix returns via RPC a page of the contacts targeted by an action with the last status recorded on each,
+// and the conversion stats of the action, joining the targets, the facts and the contacts in the database
+func (t *Action) Matrix(args models.ActionArgs, reply *models.ActionReply) error {
+	var (
+		actionStore = models.ActionStore(t.DB)
+		targets     []uint
+		rows        []models.ActionContact
+		err         error
+	)
+
+	a, err := actionStore.Brief(args)
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+	if a == nil {
+		return errors.New("matrix: action not found")
+	}
+
+	if a.Search != nil {
+		if targets, err = (&Search{Client: t.Client, DB: t.DB}).contactIDs(a.GroupID, a.Search); err != nil {
+			logs.Error(err)
+			return err
+		}
+	}
+
+	if rows, err = actionStore.Matrix(args, targets); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	reply.Stats = models.SummarizeAction(a.ID, rows)
+	reply.Contacts = models.PageActionContacts(rows, args.From, args.Size)
 
 	return nil
 }
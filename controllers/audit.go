@@ -0,0 +1,86 @@
+// Bundle of functions managing the CRUD and the elasticsearch engine
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/quorumsco/contacts/models"
+	"github.com/quorumsco/logs"
+	elastic "gopkg.in/olivere/elastic.v2"
+)
+
+// Audit contains the audit log related methods, a gorm client and an elasticsearch client
+type Audit struct {
+	DB     *gorm.DB
+	Client *elastic.Client
+}
+
+// RetrieveCollection calls the AuditSQL Find method and returns the history of a contact via RPC
+func (t *Audit) RetrieveCollection(args models.AuditArgs, reply *models.AuditReply) error {
+	var (
+		auditStore = models.AuditStore(t.DB)
+		err        error
+	)
+
+	if reply.AuditEntries, err = auditStore.Find(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Revert puts a contact back in the state recorded by an entry of its history and returns it via RPC
+func (t *Audit) Revert(args models.AuditArgs, reply *models.AuditReply) error {
+	var (
+		auditStore   = models.AuditStore(t.DB)
+		contactStore = models.ContactStore(t.DB)
+		c            models.Contact
+		err          error
+	)
+
+	e, err := auditStore.First(args)
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+	if e == nil || e.Entity != "contact" {
+		return errors.New("revert: no contact version found")
+	}
+
+	cargs := models.ContactArgs{UserID: args.UserID, Contact: &models.Contact{ID: e.EntityID, GroupID: args.GroupID}}
+	current, err := contactStore.First(cargs)
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+	if current == nil {
+		return errors.New("revert: contact not found, it has to be restored from the trash first")
+	}
+
+	if err = json.Unmarshal([]byte(e.Snapshot), &c); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	now := time.Now()
+	c.ID, c.GroupID, c.Version, c.DeletedAt = current.ID, current.GroupID, current.Version, nil
+	c.AddressID, c.Address.ID = current.AddressID, current.Address.ID
+	c.LastChange, c.LastChangeUserID = &now, args.UserID
+
+	if err = contactStore.Save(&c, cargs); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	cargs.Contact = &models.Contact{ID: c.ID, GroupID: c.GroupID}
+	if reply.Contact, err = contactStore.First(cargs); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return (&Search{Client: t.Client}).Index(models.ContactArgs{Contact: reply.Contact}, &models.ContactReply{})
+}
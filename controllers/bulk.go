@@ -0,0 +1,157 @@
+// Bundle of functions managing the CRUD and the elasticsearch engine
+package controllers
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+	"github.com/quorumsco/contacts/models"
+	"github.com/quorumsco/logs"
+	elastic "gopkg.in/olivere/elastic.v2"
+)
+
+// Bulk contains the bulk job related methods, a gorm client and an elasticsearch client
+type Bulk struct {
+	DB     *gorm.DB
+	Client *elastic.Client
+}
+
+// Create selects the contacts given by IDs or by a search, saves the job and runs it in the background.
+// The job is returned via RPC at once, its progress can be followed with Retrieve.
+func (t *Bulk) Create(args models.BulkArgs, reply *models.BulkReply) error {
+	var (
+		bulkStore = models.BulkStore(t.DB)
+		err       error
+	)
+
+	if len(args.IDs) == 0 && args.Search != nil {
+		if args.IDs, err = (&Search{Client: t.Client, DB: t.DB}).contactIDs(args.GroupID, args.Search); err != nil {
+			logs.Error(err)
+			return err
+		}
+	}
+	if len(args.IDs) == 0 {
+		return errors.New("create: no contact selected")
+	}
+
+	if err = bulkStore.Create(args.Job, args); err != nil {
+		logs.Error(err)
+		return err
+	}
+	reply.Job = args.Job
+
+	go t.run(*args.Job)
+
+	return nil
+}
+
+// Retrieve calls the BulkSQL First method and returns the progress of a job and its failed items via RPC
+func (t *Bulk) Retrieve(args models.BulkArgs, reply *models.BulkReply) error {
+	var (
+		bulkStore = models.BulkStore(t.DB)
+		err       error
+	)
+
+	if reply.Job, err = bulkStore.First(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// RetrieveCollection calls the BulkSQL Find method and returns the jobs of a group via RPC
+func (t *Bulk) RetrieveCollection(args models.BulkArgs, reply *models.BulkReply) error {
+	var (
+		bulkStore = models.BulkStore(t.DB)
+		err       error
+	)
+
+	if reply.Jobs, err = bulkStore.Find(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Rollback reverts a finished job or import and indexes again the contacts it changed. The items edited since
+// the job are reported in the returned job as conflicts; with args.DryRun they are only reported.
+func (t *Bulk) Rollback(args models.BulkArgs, reply *models.BulkReply) error {
+	var (
+		bulkStore = models.BulkStore(t.DB)
+		search    = &Search{Client: t.Client, DB: t.DB}
+		err       error
+	)
+
+	j, err := bulkStore.First(args)
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+	if j == nil {
+		return errors.New("rollback: job not found")
+	}
+
+	ids, err := bulkStore.Rollback(j, args.DryRun)
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+	reply.Job = j
+
+	// an import rolled back deletes its contacts, a deletion rolled back brings them back
+	if j.Action == models.BulkImport {
+		err = (&Trash{DB: t.DB, Client: t.Client}).unIndex(ids)
+	} else {
+		err = search.reindex(j.GroupID, ids)
+	}
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Resume runs again the jobs left unfinished when the service stopped
+func (t *Bulk) Resume() {
+	jobs, err := models.BulkStore(t.DB).Unfinished()
+	if err != nil {
+		logs.Error(err)
+		return
+	}
+
+	for _, j := range jobs {
+		t.run(j)
+	}
+}
+
+// run applies a job batch after batch, indexing again the contacts of each batch
+func (t *Bulk) run(j models.BulkJob) {
+	var (
+		bulkStore = models.BulkStore(t.DB)
+		search    = &Search{Client: t.Client, DB: t.DB}
+	)
+
+	for {
+		ids, err := bulkStore.RunBatch(&j)
+		if err != nil {
+			logs.Error(err)
+			return
+		}
+		if len(ids) == 0 && j.Status != models.JobRunning {
+			logs.Info("bulk job %d of group %d: %d done, %d failed", j.ID, j.GroupID, j.Done, j.Failed)
+			return
+		}
+
+		if j.Action == models.BulkDelete {
+			err = (&Trash{DB: t.DB, Client: t.Client}).unIndex(ids)
+		} else {
+			err = search.reindex(j.GroupID, ids)
+		}
+		if err != nil {
+			logs.Error(err)
+		}
+	}
+}
@@ -0,0 +1,624 @@
+// Bundle of functions managing the CRUD and the elasticsearch engine
+package controllers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/quorumsco/contacts/models"
+	"github.com/quorumsco/logs"
+)
+
+// CardDAVPrefix is the path the CardDAV handler is mounted on
+const CardDAVPrefix = "/carddav/"
+
+const (
+	davNS     = "DAV:"
+	cardNS    = "urn:ietf:params:xml:ns:carddav"
+	csNS      = "http://calendarserver.org/ns/"
+	syncToken = "urn:quorums:sync:"
+)
+
+// CardDAV serves the contacts of a group, or of one of its missions, as read-only CardDAV address books:
+//
+//	/carddav/{group_id}/                          address book home
+//	/carddav/{group_id}/contacts/                 every contact of the group
+//	/carddav/{group_id}/mission-{mission_id}/     the contacts of a mission
+//	/carddav/{group_id}/{book}/{contact_id}.vcf   a single contact
+//
+// Authentication is left to the gateway proxying the requests.
+type CardDAV struct {
+	DB *gorm.DB
+}
+
+// cardDAVBook is an address book resolved from the request path
+type cardDAVBook struct {
+	GroupID   uint
+	MissionID uint
+	Href      string
+	Name      string
+}
+
+// davRequest holds the parts of PROPFIND and REPORT bodies we care about
+type davRequest struct {
+	XMLName   xml.Name
+	AllProp   *struct{} `xml:"allprop"`
+	Prop      davProp   `xml:"prop"`
+	Hrefs     []string  `xml:"href"`
+	SyncToken string    `xml:"sync-token"`
+}
+
+type davProp struct {
+	Names []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+// ServeHTTP dispatches the CardDAV requests
+func (t *CardDAV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	groupID, book, contactID, err := t.parsePath(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "OPTIONS":
+		w.Header().Set("DAV", "1, 3, addressbook")
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND, REPORT")
+	case "GET", "HEAD":
+		if book == nil || contactID == 0 {
+			http.Error(w, "not an address object", http.StatusMethodNotAllowed)
+			return
+		}
+		err = t.get(w, r, book, contactID)
+	case "PROPFIND":
+		err = t.propfind(w, r, groupID, book, contactID)
+	case "REPORT":
+		if book == nil {
+			http.Error(w, "not an address book", http.StatusForbidden)
+			return
+		}
+		err = t.report(w, r, book)
+	case "PUT", "DELETE", "PROPPATCH", "MKCOL", "COPY", "MOVE":
+		http.Error(w, "address books are read-only", http.StatusForbidden)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+
+	if err != nil {
+		logs.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// parsePath extracts the group, the address book and the contact from the request path
+func (t *CardDAV) parsePath(path string) (uint, *cardDAVBook, uint, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, CardDAVPrefix), "/"), "/")
+
+	groupID, err := strconv.Atoi(parts[0])
+	if err != nil || groupID <= 0 {
+		return 0, nil, 0, fmt.Errorf("carddav: invalid group %q", parts[0])
+	}
+	if len(parts) == 1 {
+		return uint(groupID), nil, 0, nil
+	}
+
+	book := &cardDAVBook{GroupID: uint(groupID), Href: fmt.Sprintf("%s%d/%s/", CardDAVPrefix, groupID, parts[1])}
+	switch {
+	case parts[1] == "contacts":
+		book.Name = "Contacts"
+	case strings.HasPrefix(parts[1], "mission-"):
+		missionID, err := strconv.Atoi(strings.TrimPrefix(parts[1], "mission-"))
+		if err != nil || missionID <= 0 {
+			return 0, nil, 0, fmt.Errorf("carddav: invalid address book %q", parts[1])
+		}
+		m, err := models.MissionStore(t.DB).First(models.MissionArgs{Mission: &models.Mission{ID: uint(missionID), GroupID: uint(groupID)}})
+		if err != nil || m == nil {
+			return 0, nil, 0, fmt.Errorf("carddav: unknown address book %q", parts[1])
+		}
+		book.MissionID = m.ID
+		book.Name = fmt.Sprintf("Mission %d", m.ID)
+	default:
+		return 0, nil, 0, fmt.Errorf("carddav: unknown address book %q", parts[1])
+	}
+
+	if len(parts) == 2 {
+		return uint(groupID), book, 0, nil
+	}
+
+	contactID, err := strconv.Atoi(strings.TrimSuffix(parts[2], ".vcf"))
+	if len(parts) > 3 || err != nil || contactID <= 0 {
+		return 0, nil, 0, fmt.Errorf("carddav: invalid address object %q", parts[2])
+	}
+
+	return uint(groupID), book, uint(contactID), nil
+}
+
+// contacts returns the contacts of the address book changed after since, or all of them if since is nil.
+// As in VCard.ExportCollection, the contacts which have not consented to any channel are left out.
+func (t *CardDAV) contacts(book *cardDAVBook, since *time.Time) ([]models.Contact, error) {
+	members, err := t.members(book, since)
+	if err != nil {
+		return nil, err
+	}
+
+	reachable, _, err := t.consented(book, members)
+	return reachable, err
+}
+
+// members returns the contacts of the address book changed after since, or all of them if since is nil, whatever their consents
+func (t *CardDAV) members(book *cardDAVBook, since *time.Time) ([]models.Contact, error) {
+	var (
+		contactStore = models.ContactStore(t.DB)
+		args         = models.ContactArgs{MissionID: book.MissionID, Contact: &models.Contact{GroupID: book.GroupID}}
+	)
+
+	if book.MissionID == 0 {
+		return contactStore.FindModifiedSince(since, args)
+	}
+
+	members, err := contactStore.FindByMission(&models.Mission{ID: book.MissionID, GroupID: book.GroupID}, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint
+	for _, c := range members {
+		if since == nil || (c.LastChange != nil && c.LastChange.After(*since)) {
+			ids = append(ids, c.ID)
+		}
+	}
+
+	return contactStore.FindByIDs(ids, args)
+}
+
+// consented splits the contacts between the ones which can be reached through at least one channel and the others
+func (t *CardDAV) consented(book *cardDAVBook, contacts []models.Contact) ([]models.Contact, []models.Contact, error) {
+	var (
+		ids                    []uint
+		allowed                = make(map[uint]bool)
+		reachable, unreachable []models.Contact
+	)
+
+	for _, c := range contacts {
+		ids = append(ids, c.ID)
+	}
+	for _, channel := range models.ConsentChannels {
+		reached, err := models.ConsentStore(t.DB).Allowed(models.ConsentArgs{GroupID: book.GroupID}, channel, ids)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, id := range reached {
+			allowed[id] = true
+		}
+	}
+
+	for _, c := range contacts {
+		if allowed[c.ID] {
+			reachable = append(reachable, c)
+		} else {
+			unreachable = append(unreachable, c)
+		}
+	}
+
+	return reachable, unreachable, nil
+}
+
+// contact returns a single contact of the address book, nil if it isn't part of it
+func (t *CardDAV) contact(book *cardDAVBook, contactID uint) (*models.Contact, error) {
+	contacts, err := t.contacts(book, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range contacts {
+		if contacts[i].ID == contactID {
+			return &contacts[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// get returns the vCard of a contact
+func (t *CardDAV) get(w http.ResponseWriter, r *http.Request, book *cardDAVBook, contactID uint) error {
+	c, err := t.contact(book, contactID)
+	if err != nil {
+		return err
+	}
+	if c == nil {
+		http.NotFound(w, r)
+		return nil
+	}
+
+	etag := cardETag(c)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	if err = (&VCard{DB: t.DB}).loadRelations(c); err != nil {
+		return err
+	}
+	c.StripUnconsented()
+
+	w.Header().Set("Content-Type", "text/vcard; charset=utf-8")
+	if r.Method == "GET" {
+		io.WriteString(w, c.VCard())
+	}
+
+	return nil
+}
+
+// propfind answers the PROPFIND requests on the home, the address books and the address objects
+func (t *CardDAV) propfind(w http.ResponseWriter, r *http.Request, groupID uint, book *cardDAVBook, contactID uint) error {
+	req, err := parseDAVRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	depth := r.Header.Get("Depth")
+
+	var ms bytes.Buffer
+	switch {
+	case book == nil:
+		home := fmt.Sprintf("%s%d/", CardDAVPrefix, groupID)
+		writeDAVResponse(&ms, home, req, map[xml.Name]string{
+			{Space: davNS, Local: "resourcetype"}:               "<d:collection/>",
+			{Space: davNS, Local: "displayname"}:                fmt.Sprintf("Group %d", groupID),
+			{Space: davNS, Local: "current-user-principal"}:     "<d:href>" + home + "</d:href>",
+			{Space: cardNS, Local: "addressbook-home-set"}:      "<d:href>" + home + "</d:href>",
+			{Space: davNS, Local: "principal-URL"}:              "<d:href>" + home + "</d:href>",
+			{Space: davNS, Local: "current-user-privilege-set"}: "<d:privilege><d:read/></d:privilege>",
+			{Space: davNS, Local: "principal-collection-set"}:   "<d:href>" + CardDAVPrefix + "</d:href>",
+			{Space: davNS, Local: "owner"}:                      "<d:href>" + home + "</d:href>",
+		})
+		if depth == "0" {
+			break
+		}
+
+		books := []*cardDAVBook{{GroupID: groupID, Href: home + "contacts/", Name: "Contacts"}}
+		missions, err := models.MissionStore(t.DB).Find(models.MissionArgs{Mission: &models.Mission{GroupID: groupID}})
+		if err != nil {
+			return err
+		}
+		for _, m := range missions {
+			books = append(books, &cardDAVBook{GroupID: groupID, MissionID: m.ID, Href: fmt.Sprintf("%smission-%d/", home, m.ID), Name: fmt.Sprintf("Mission %d", m.ID)})
+		}
+		for _, b := range books {
+			if err = t.writeBookResponse(&ms, b, req); err != nil {
+				return err
+			}
+		}
+	case contactID != 0:
+		c, err := t.contact(book, contactID)
+		if err != nil {
+			return err
+		}
+		if c == nil {
+			http.NotFound(w, r)
+			return nil
+		}
+		if err = t.writeCardResponse(&ms, book, c, req); err != nil {
+			return err
+		}
+	default:
+		if err = t.writeBookResponse(&ms, book, req); err != nil {
+			return err
+		}
+		if depth == "0" {
+			break
+		}
+
+		contacts, err := t.contacts(book, nil)
+		if err != nil {
+			return err
+		}
+		for i := range contacts {
+			if err = t.writeCardResponse(&ms, book, &contacts[i], req); err != nil {
+				return err
+			}
+		}
+	}
+
+	writeMultistatus(w, ms.String(), "")
+
+	return nil
+}
+
+// report answers the addressbook-multiget, addressbook-query and sync-collection REPORT requests
+func (t *CardDAV) report(w http.ResponseWriter, r *http.Request, book *cardDAVBook) error {
+	req, err := parseDAVRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	var ms bytes.Buffer
+	switch req.XMLName.Local {
+	case "addressbook-multiget":
+		contacts, err := t.contacts(book, nil)
+		if err != nil {
+			return err
+		}
+		byHref := make(map[string]*models.Contact)
+		for i := range contacts {
+			byHref[cardHref(book, &contacts[i])] = &contacts[i]
+		}
+		for _, href := range req.Hrefs {
+			c, ok := byHref[strings.TrimSpace(href)]
+			if !ok {
+				fmt.Fprintf(&ms, "<d:response><d:href>%s</d:href><d:status>HTTP/1.1 404 Not Found</d:status></d:response>", escapeDAV(href))
+				continue
+			}
+			if err = t.writeCardResponse(&ms, book, c, req); err != nil {
+				return err
+			}
+		}
+		writeMultistatus(w, ms.String(), "")
+	case "addressbook-query":
+		contacts, err := t.contacts(book, nil)
+		if err != nil {
+			return err
+		}
+		for i := range contacts {
+			if err = t.writeCardResponse(&ms, book, &contacts[i], req); err != nil {
+				return err
+			}
+		}
+		writeMultistatus(w, ms.String(), "")
+	case "sync-collection":
+		token := currentSyncToken()
+		members, err := t.members(book, nil)
+		if err != nil {
+			return err
+		}
+		all, unreachable, err := t.consented(book, members)
+		if err != nil {
+			return err
+		}
+
+		since, ok := parseSyncToken(req.SyncToken)
+		if ok && since != nil {
+			ok, err = t.inRetention(book, *since)
+			if err != nil {
+				return err
+			}
+		}
+		// purged contacts can't be reported anymore, the client has to start over
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, xml.Header+`<d:error xmlns:d="DAV:"><d:valid-sync-token/></d:error>`)
+			return nil
+		}
+
+		for i := range all {
+			if since == nil || (all[i].LastChange != nil && all[i].LastChange.After(*since)) {
+				if err = t.writeCardResponse(&ms, book, &all[i], req); err != nil {
+					return err
+				}
+			}
+		}
+
+		var deleted []models.Contact
+		if since != nil {
+			args := models.ContactArgs{MissionID: book.MissionID, Contact: &models.Contact{GroupID: book.GroupID}}
+			if deleted, err = models.ContactStore(t.DB).FindDeletedSince(*since, args); err != nil {
+				return err
+			}
+			// a contact withdrawing its last consent leaves the address book
+			for _, c := range unreachable {
+				if c.LastChange != nil && c.LastChange.After(*since) {
+					deleted = append(deleted, c)
+				}
+			}
+			for i := range deleted {
+				fmt.Fprintf(&ms, "<d:response><d:href>%s</d:href><d:status>HTTP/1.1 404 Not Found</d:status></d:response>", escapeDAV(cardHref(book, &deleted[i])))
+			}
+		}
+		writeMultistatus(w, ms.String(), token)
+	default:
+		http.Error(w, "unsupported report "+req.XMLName.Local, http.StatusForbidden)
+	}
+
+	return nil
+}
+
+// inRetention reports whether the trash of the group still holds every contact deleted since the given date
+func (t *CardDAV) inRetention(book *cardDAVBook, since time.Time) (bool, error) {
+	gs, err := models.GroupSettingStore(t.DB).First(models.GroupSettingArgs{GroupSetting: &models.GroupSetting{GroupID: book.GroupID}})
+	if err != nil {
+		return false, err
+	}
+
+	retention := gs.TrashRetention
+	if retention == 0 {
+		retention = models.DefaultTrashRetention
+	}
+
+	return since.After(time.Now().AddDate(0, 0, -int(retention))), nil
+}
+
+// writeBookResponse writes the properties of an address book
+func (t *CardDAV) writeBookResponse(b *bytes.Buffer, book *cardDAVBook, req *davRequest) error {
+	contacts, err := t.contacts(book, nil)
+	if err != nil {
+		return err
+	}
+
+	writeDAVResponse(b, book.Href, req, map[xml.Name]string{
+		{Space: davNS, Local: "resourcetype"}:               "<d:collection/><card:addressbook/>",
+		{Space: davNS, Local: "displayname"}:                escapeDAV(book.Name),
+		{Space: davNS, Local: "sync-token"}:                 currentSyncToken(),
+		{Space: csNS, Local: "getctag"}:                     escapeDAV(bookCTag(contacts)),
+		{Space: davNS, Local: "current-user-privilege-set"}: "<d:privilege><d:read/></d:privilege>",
+		{Space: cardNS, Local: "supported-address-data"}:    `<card:address-data-type content-type="text/vcard" version="4.0"/>`,
+		{Space: cardNS, Local: "addressbook-description"}:   escapeDAV(book.Name),
+		{Space: davNS, Local: "supported-report-set"}: "<d:supported-report><d:report><card:addressbook-multiget/></d:report></d:supported-report>" +
+			"<d:supported-report><d:report><card:addressbook-query/></d:report></d:supported-report>" +
+			"<d:supported-report><d:report><d:sync-collection/></d:report></d:supported-report>",
+	})
+
+	return nil
+}
+
+// writeCardResponse writes the properties of an address object
+func (t *CardDAV) writeCardResponse(b *bytes.Buffer, book *cardDAVBook, c *models.Contact, req *davRequest) error {
+	props := map[xml.Name]string{
+		{Space: davNS, Local: "getetag"}:        escapeDAV(cardETag(c)),
+		{Space: davNS, Local: "getcontenttype"}: "text/vcard; charset=utf-8",
+		{Space: davNS, Local: "resourcetype"}:   "",
+	}
+	if req.wants(xml.Name{Space: cardNS, Local: "address-data"}) {
+		if err := (&VCard{DB: t.DB}).loadRelations(c); err != nil {
+			return err
+		}
+		c.StripUnconsented()
+		props[xml.Name{Space: cardNS, Local: "address-data"}] = escapeDAV(c.VCard())
+	}
+
+	writeDAVResponse(b, cardHref(book, c), req, props)
+
+	return nil
+}
+
+// wants reports whether the property was explicitly requested
+func (req *davRequest) wants(name xml.Name) bool {
+	for _, n := range req.Prop.Names {
+		if n.XMLName == name {
+			return true
+		}
+	}
+	return false
+}
+
+func parseDAVRequest(r *http.Request) (*davRequest, error) {
+	var req davRequest
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		req.AllProp = &struct{}{}
+		return &req, nil
+	}
+	if err = xml.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	if len(req.Prop.Names) == 0 {
+		req.AllProp = &struct{}{}
+	}
+
+	return &req, nil
+}
+
+// writeDAVResponse writes a response element, sorting the requested properties into found and not found
+func writeDAVResponse(b *bytes.Buffer, href string, req *davRequest, props map[xml.Name]string) {
+	var found, missing bytes.Buffer
+
+	if req.AllProp != nil {
+		for name, value := range props {
+			writeDAVProp(&found, name, value)
+		}
+	} else {
+		for _, n := range req.Prop.Names {
+			if value, ok := props[n.XMLName]; ok {
+				writeDAVProp(&found, n.XMLName, value)
+			} else {
+				writeDAVProp(&missing, n.XMLName, "")
+			}
+		}
+	}
+
+	fmt.Fprintf(b, "<d:response><d:href>%s</d:href>", escapeDAV(href))
+	if found.Len() > 0 {
+		fmt.Fprintf(b, "<d:propstat><d:prop>%s</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>", found.String())
+	}
+	if missing.Len() > 0 {
+		fmt.Fprintf(b, "<d:propstat><d:prop>%s</d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>", missing.String())
+	}
+	b.WriteString("</d:response>")
+}
+
+func writeDAVProp(b *bytes.Buffer, name xml.Name, value string) {
+	fmt.Fprintf(b, `<%s xmlns="%s">%s</%s>`, name.Local, escapeDAV(name.Space), value, name.Local)
+}
+
+func writeMultistatus(w http.ResponseWriter, responses string, token string) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(207)
+
+	io.WriteString(w, xml.Header)
+	fmt.Fprintf(w, `<d:multistatus xmlns:d="%s" xmlns:card="%s" xmlns:cs="%s">`, davNS, cardNS, csNS)
+	io.WriteString(w, responses)
+	if token != "" {
+		fmt.Fprintf(w, "<d:sync-token>%s</d:sync-token>", token)
+	}
+	io.WriteString(w, "</d:multistatus>")
+}
+
+func escapeDAV(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func cardHref(book *cardDAVBook, c *models.Contact) string {
+	return fmt.Sprintf("%s%d.vcf", book.Href, c.ID)
+}
+
+// cardETag derives the ETag of an address object from its last change
+func cardETag(c *models.Contact) string {
+	var rev int64
+	if c.LastChange != nil {
+		rev = c.LastChange.UnixNano()
+	}
+	return fmt.Sprintf(`"%d-%d"`, c.ID, rev)
+}
+
+// currentSyncToken encodes the date of the sync, the next one reports what changed or got deleted after it
+func currentSyncToken() string {
+	return fmt.Sprintf("%s%d", syncToken, time.Now().UnixNano())
+}
+
+// bookCTag changes whenever a contact of the address book is added, changed or removed
+func bookCTag(contacts []models.Contact) string {
+	var latest int64
+	for _, c := range contacts {
+		if c.LastChange != nil && c.LastChange.UnixNano() > latest {
+			latest = c.LastChange.UnixNano()
+		}
+	}
+	return fmt.Sprintf(`"%d-%d"`, latest, len(contacts))
+}
+
+// parseSyncToken decodes a token built by currentSyncToken, an empty token asks for an initial sync
+func parseSyncToken(token string) (*time.Time, bool) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, true
+	}
+	if !strings.HasPrefix(token, syncToken) {
+		return nil, false
+	}
+
+	latest, err := strconv.ParseInt(strings.TrimPrefix(token, syncToken), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	since := time.Unix(0, latest)
+	return &since, true
+}
@@ -0,0 +1,74 @@
+// Bundle of functions managing the CRUD and the elasticsearch engine
+package controllers
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/quorumsco/contacts/models"
+	"github.com/quorumsco/logs"
+	elastic "gopkg.in/olivere/elastic.v2"
+)
+
+// Consent contains the consent related methods, a gorm client and an elasticsearch client
+type Consent struct {
+	DB     *gorm.DB
+	Client *elastic.Client
+}
+
+// RetrieveCollection calls the ConsentSQL Find method and returns the consent history of a contact via RPC
+func (t *Consent) RetrieveCollection(args models.ConsentArgs, reply *models.ConsentReply) error {
+	var (
+		consentStore = models.ConsentStore(t.DB)
+		err          error
+	)
+
+	if reply.Consents, err = consentStore.Find(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Retrieve calls the ConsentSQL Current method and returns the current consent of a contact by channel via RPC
+func (t *Consent) Retrieve(args models.ConsentArgs, reply *models.ConsentReply) error {
+	var (
+		consentStore = models.ConsentStore(t.DB)
+		err          error
+	)
+
+	if reply.Consents, err = consentStore.Current(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Create calls the ConsentSQL Save method, indexes the contact again for the consent filters and returns the record via RPC
+func (t *Consent) Create(args models.ConsentArgs, reply *models.ConsentReply) error {
+	var (
+		consentStore = models.ConsentStore(t.DB)
+		contactStore = models.ContactStore(t.DB)
+		err          error
+	)
+
+	if args.Consent != nil {
+		args.Consent.ContactID = args.ContactID
+	}
+	if err = consentStore.Save(args.Consent, args); err != nil {
+		logs.Error(err)
+		return err
+	}
+	reply.Consent = args.Consent
+
+	c, err := contactStore.First(models.ContactArgs{Contact: &models.Contact{ID: args.ContactID, GroupID: args.GroupID}})
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+	if c == nil {
+		return nil
+	}
+
+	return (&Search{Client: t.Client}).Index(models.ContactArgs{Contact: c}, &models.ContactReply{})
+}
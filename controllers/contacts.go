@@ -5,11 +5,13 @@ import (
 	"github.com/jinzhu/gorm"
 	"github.com/quorumsco/contacts/models"
 	"github.com/quorumsco/logs"
+	elastic "gopkg.in/olivere/elastic.v2"
 )
 
-// Contact contains the contact related methods and a gorm client
+// Contact contains the contact related methods, a gorm client and an elasticsearch client
 type Contact struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Client *elastic.Client
 }
 
 // RetrieveCollection calls the ContactSQL Find method and returns the results via RPC
@@ -66,7 +68,15 @@ func (t *Contact) Update(args models.ContactArgs, reply *models.ContactReply) er
 		err          error
 	)
 
+	// lenient groups save the invalid fields as entered, the errors are reported all the same
+	if reply.Errors = args.Contact.Validate(); len(reply.Errors) == 0 {
+		reply.Errors = nil
+	}
 	if err = contactStore.Save(args.Contact, args); err != nil {
+		if verr, ok := err.(*models.ValidationError); ok {
+			reply.Errors = verr.Fields
+			return nil
+		}
 		logs.Error(err)
 		return err
 	}
@@ -88,7 +98,15 @@ func (t *Contact) Create(args models.ContactArgs, reply *models.ContactReply) er
 		err          error
 	)
 
+	// lenient groups save the invalid fields as entered, the errors are reported all the same
+	if reply.Errors = args.Contact.Validate(); len(reply.Errors) == 0 {
+		reply.Errors = nil
+	}
 	if err = contactStore.Save(args.Contact, args); err != nil {
+		if verr, ok := err.(*models.ValidationError); ok {
+			reply.Errors = verr.Fields
+			return nil
+		}
 		logs.Error(err)
 		return err
 	}
@@ -104,7 +122,7 @@ func (t *Contact) Create(args models.ContactArgs, reply *models.ContactReply) er
 	return nil
 }
 
-// Delete calls the ContactSQL Delete method and returns the results via RPC
+// Delete calls the ContactSQL Delete method, which moves the contact to the trash, and unindexes it
 func (t *Contact) Delete(args models.ContactArgs, reply *models.ContactReply) error {
 	var (
 		contactStore = models.ContactStore(t.DB)
@@ -116,5 +134,9 @@ func (t *Contact) Delete(args models.ContactArgs, reply *models.ContactReply) er
 		return err
 	}
 
+	if t.Client != nil {
+		return (&Search{Client: t.Client}).UnIndex(args, reply)
+	}
+
 	return nil
 }
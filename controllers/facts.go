@@ -67,6 +67,21 @@ func (t *Fact) Create(args models.FactArgs, reply *models.FactReply) error {
 	return nil
 }
 
+// Geofence calls the FactSQL Geofence method and returns the report of the suspicious facts by volunteer via RPC
+func (t *Fact) Geofence(args models.FactArgs, reply *models.FactReply) error {
+	var (
+		factStore = models.FactStore(t.DB)
+		err       error
+	)
+
+	if reply.Geofence, err = factStore.Geofence(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
 // Delete calls the FactSQL Delete method and returns the results via RPC
 func (t *Fact) Delete(args models.FactArgs, reply *models.FactReply) error {
 	var (
@@ -0,0 +1,113 @@
+// Bundle of functions managing the CRUD and the elasticsearch engine
+package controllers
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/quorumsco/contacts/models"
+	"github.com/quorumsco/logs"
+)
+
+// Form contains the form definition related methods and a gorm client
+type Form struct {
+	DB *gorm.DB
+}
+
+// RetrieveCollection calls the FormSQL Find method and returns the forms of a group via RPC
+func (t *Form) RetrieveCollection(args models.FormArgs, reply *models.FormReply) error {
+	var (
+		formStore = models.FormStore(t.DB)
+		err       error
+	)
+
+	if reply.Forms, err = formStore.Find(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Retrieve calls the FormSQL First method and returns the results via RPC
+func (t *Form) Retrieve(args models.FormArgs, reply *models.FormReply) error {
+	var (
+		formStore = models.FormStore(t.DB)
+		err       error
+	)
+
+	if reply.Form, err = formStore.First(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Create calls the FormSQL Save method and returns the results via RPC
+func (t *Form) Create(args models.FormArgs, reply *models.FormReply) error {
+	var (
+		formStore = models.FormStore(t.DB)
+		err       error
+	)
+
+	if args.Form != nil {
+		args.Form.ID = 0
+	}
+	if err = formStore.Save(args.Form, args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	reply.Form = args.Form
+
+	return nil
+}
+
+// Update calls the FormSQL Save method, which keeps the previous version of the form, and returns the results via RPC
+func (t *Form) Update(args models.FormArgs, reply *models.FormReply) error {
+	var (
+		formStore = models.FormStore(t.DB)
+		err       error
+	)
+
+	if err = formStore.Save(args.Form, args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	if reply.Form, err = formStore.First(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Delete calls the FormSQL Delete method and returns the results via RPC
+func (t *Form) Delete(args models.FormArgs, reply *models.FormReply) error {
+	var (
+		formStore = models.FormStore(t.DB)
+		err       error
+	)
+
+	if err = formStore.Delete(args.Form, args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Revisions calls the FormSQL Revisions method and returns the previous versions of a form via RPC
+func (t *Form) Revisions(args models.FormArgs, reply *models.FormReply) error {
+	var (
+		formStore = models.FormStore(t.DB)
+		err       error
+	)
+
+	if reply.Revisions, err = formStore.Revisions(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
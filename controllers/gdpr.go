@@ -0,0 +1,79 @@
+// Bundle of functions managing the CRUD and the elasticsearch engine
+package controllers
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/quorumsco/contacts/models"
+	"github.com/quorumsco/logs"
+	elastic "gopkg.in/olivere/elastic.v2"
+)
+
+// GDPR contains the subject access and erasure methods, a gorm client and an elasticsearch client
+type GDPR struct {
+	DB     *gorm.DB
+	Client *elastic.Client
+}
+
+// Access calls the GDPRSQL Access method and returns everything held about a contact, as well as a ZIP archive of it, via RPC
+func (t *GDPR) Access(args models.GDPRArgs, reply *models.GDPRReply) error {
+	var (
+		gdprStore = models.GDPRStore(t.DB)
+		err       error
+	)
+
+	if reply.SubjectAccess, err = gdprStore.Access(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	if reply.Data, err = reply.SubjectAccess.Zip(); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Erase calls the GDPRSQL Erase method, removes the contact from the elasticsearch indices
+// and returns the erasure record along with what remains, which should be nothing, via RPC
+func (t *GDPR) Erase(args models.GDPRArgs, reply *models.GDPRReply) error {
+	var (
+		gdprStore = models.GDPRStore(t.DB)
+		err       error
+	)
+
+	if reply.Erasure, err = gdprStore.Erase(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	if err = (&Trash{DB: t.DB, Client: t.Client}).unIndex([]uint{args.ContactID}); err != nil {
+		return err
+	}
+
+	return t.Verify(args, reply)
+}
+
+// Verify counts by SQL table and elasticsearch index what is still held about a contact and returns it via RPC
+func (t *GDPR) Verify(args models.GDPRArgs, reply *models.GDPRReply) error {
+	var (
+		gdprStore = models.GDPRStore(t.DB)
+		err       error
+	)
+
+	if reply.Remaining, err = gdprStore.Remaining(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	for _, index := range []struct{ name, field string }{{"contacts", "id"}, {"facts", "contact_id"}} {
+		n, err := t.Client.Count(index.name).Query(elastic.NewTermQuery(index.field, args.ContactID)).Do()
+		if err != nil {
+			logs.Error(err)
+			return err
+		}
+		reply.Remaining["elasticsearch:"+index.name] = int(n)
+	}
+
+	return nil
+}
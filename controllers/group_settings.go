@@ -0,0 +1,45 @@
+// Bundle of functions managing the CRUD and the elasticsearch engine
+package controllers
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/quorumsco/contacts/models"
+	"github.com/quorumsco/logs"
+)
+
+// GroupSetting contains the group setting related methods and a gorm client
+type GroupSetting struct {
+	DB *gorm.DB
+}
+
+// Retrieve calls the GroupSettingSQL First method and returns the results via RPC
+func (t *GroupSetting) Retrieve(args models.GroupSettingArgs, reply *models.GroupSettingReply) error {
+	var (
+		groupSettingStore = models.GroupSettingStore(t.DB)
+		err               error
+	)
+
+	if reply.GroupSetting, err = groupSettingStore.First(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Update calls the GroupSettingSQL Save method and returns the results via RPC
+func (t *GroupSetting) Update(args models.GroupSettingArgs, reply *models.GroupSettingReply) error {
+	var (
+		groupSettingStore = models.GroupSettingStore(t.DB)
+		err               error
+	)
+
+	if err = groupSettingStore.Save(args.GroupSetting, args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	reply.GroupSetting = args.GroupSetting
+
+	return nil
+}
@@ -0,0 +1,58 @@
+// Bundle of functions managing the CRUD and the elasticsearch engine
+package controllers
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/quorumsco/contacts/models"
+	"github.com/quorumsco/logs"
+)
+
+// Lease contains the visit lease related methods and a gorm client
+type Lease struct {
+	DB *gorm.DB
+}
+
+// Claim calls the LeaseSQL Claim method and returns the lease of the building via RPC
+func (t *Lease) Claim(args models.LeaseArgs, reply *models.LeaseReply) error {
+	var (
+		leaseStore = models.LeaseStore(t.DB)
+		err        error
+	)
+
+	if reply.Lease, err = leaseStore.Claim(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Release calls the LeaseSQL Release method
+func (t *Lease) Release(args models.LeaseArgs, reply *models.LeaseReply) error {
+	var (
+		leaseStore = models.LeaseStore(t.DB)
+		err        error
+	)
+
+	if err = leaseStore.Release(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// RetrieveCollection calls the LeaseSQL Find method and returns the running leases of a group via RPC
+func (t *Lease) RetrieveCollection(args models.LeaseArgs, reply *models.LeaseReply) error {
+	var (
+		leaseStore = models.LeaseStore(t.DB)
+		err        error
+	)
+
+	if reply.Leases, err = leaseStore.Find(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
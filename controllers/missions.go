@@ -2,14 +2,19 @@
 package controllers
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/jinzhu/gorm"
 	"github.com/quorumsco/contacts/models"
 	"github.com/quorumsco/logs"
+	elastic "gopkg.in/olivere/elastic.v2"
 )
 
-// Mission contains the mission related methods and a gorm client
+// Mission contains the mission related methods, a gorm client and an elasticsearch client
 type Mission struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Client *elastic.Client
 }
 
 // RetrieveCollection calls the MissionSQL Find method and returns the results via RPC
@@ -41,7 +46,11 @@ func (t *Mission) Create(args models.MissionArgs, reply *models.MissionReply) er
 
 	reply.Mission = args.Mission
 
-	return nil
+	if reply.ContactIDs, err = t.populate(args.Mission); err != nil {
+		return err
+	}
+
+	return t.reindex(args, nil)
 }
 
 // Update calls the MissionSQL First method and returns the results via RPC
@@ -51,7 +60,9 @@ func (t *Mission) Update(args models.MissionArgs, reply *models.MissionReply) er
 		err          error
 	)
 
-	if reply.Mission, err = missionStore.First(args); err != nil {
+	before, err := missionStore.ContactIDs(args)
+	if err != nil {
+		logs.Error(err)
 		return err
 	}
 
@@ -59,8 +70,35 @@ func (t *Mission) Update(args models.MissionArgs, reply *models.MissionReply) er
 		logs.Error(err)
 		return err
 	}
+	reply.Mission = args.Mission
 
-	return nil
+	if reply.ContactIDs, err = t.populate(args.Mission); err != nil {
+		return err
+	}
+
+	return t.reindex(args, before)
+}
+
+// Populate adds to a mission the contacts of its territory matching its search and returns them via RPC
+func (t *Mission) Populate(args models.MissionArgs, reply *models.MissionReply) error {
+	var (
+		missionStore = models.MissionStore(t.DB)
+		err          error
+	)
+
+	if reply.Mission, err = missionStore.First(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+	if reply.Mission == nil {
+		return errors.New("populate: mission not found")
+	}
+
+	if reply.ContactIDs, err = t.populate(reply.Mission); err != nil {
+		return err
+	}
+
+	return t.reindex(models.MissionArgs{Mission: reply.Mission}, nil)
 }
 
 // Delete calls the MissionSQL Delete method and returns the results via RPC
@@ -70,10 +108,163 @@ func (t *Mission) Delete(args models.MissionArgs, reply *models.MissionReply) er
 		err          error
 	)
 
+	before, err := missionStore.ContactIDs(args)
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+
 	if err = missionStore.Delete(args.Mission, args); err != nil {
 		logs.Debug(err)
 		return err
 	}
 
+	return t.reindex(args, before)
+}
+
+// Route plans the door-to-door walk through the buildings of a mission and returns it via RPC
+func (t *Mission) Route(args models.MissionArgs, reply *models.MissionReply) error {
+	var (
+		missionStore = models.MissionStore(t.DB)
+		contactStore = models.ContactStore(t.DB)
+		err          error
+	)
+
+	if reply.Mission, err = missionStore.First(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+	if reply.Mission == nil {
+		return errors.New("route: mission not found")
+	}
+
+	contacts, err := contactStore.FindByMission(reply.Mission, models.ContactArgs{})
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	reply.Route = models.PlanRoute(contacts, args.Start)
+	reply.Route.MissionID = reply.Mission.ID
+
 	return nil
 }
+
+// Partition cuts the contacts of a mission into walk lists of about the same number of doors and returns them via RPC.
+// With args.Create, a sub-mission is created for every list.
+func (t *Mission) Partition(args models.MissionArgs, reply *models.MissionReply) error {
+	var (
+		missionStore = models.MissionStore(t.DB)
+		contactStore = models.ContactStore(t.DB)
+		err          error
+	)
+
+	if reply.Mission, err = missionStore.First(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+	if reply.Mission == nil {
+		return errors.New("partition: mission not found")
+	}
+	m := reply.Mission
+
+	contacts, err := contactStore.FindByMission(m, models.ContactArgs{})
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	if reply.Partition, err = models.PartitionTerritory(contacts, args.Parts); err != nil {
+		return err
+	}
+	reply.Partition.MissionID = m.ID
+
+	if !args.Create {
+		return nil
+	}
+
+	for i := range reply.Partition.Lists {
+		l := &reply.Partition.Lists[i]
+		sub := &models.Mission{
+			Name:        fmt.Sprintf("%s #%d", m.Name, l.Rank),
+			Description: m.Description,
+			Status:      models.MissionDraft,
+			Date:        m.Date,
+			Start:       m.Start,
+			End:         m.End,
+			GroupID:     m.GroupID,
+			ParentID:    m.ID,
+		}
+		sargs := models.MissionArgs{UserID: args.UserID, Mission: sub}
+		if err = missionStore.Save(sub, sargs); err != nil {
+			logs.Error(err)
+			return err
+		}
+		if err = missionStore.AddContacts(sargs, l.ContactIDs); err != nil {
+			logs.Error(err)
+			return err
+		}
+		l.MissionID = sub.ID
+
+		if err = t.reindex(sargs, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Progress computes the visits of the contacts of a mission and returns them via RPC
+func (t *Mission) Progress(args models.MissionArgs, reply *models.MissionReply) error {
+	var (
+		missionStore = models.MissionStore(t.DB)
+		err          error
+	)
+
+	if reply.Progress, err = missionStore.Progress(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// populate adds the contacts of the territory matching the search of a mission, if it has any of them
+func (t *Mission) populate(m *models.Mission) ([]uint, error) {
+	if t.Client == nil || (len(m.Polygon) == 0 && m.Search == nil) {
+		return nil, nil
+	}
+
+	search := &models.Search{}
+	if m.Search != nil {
+		*search = *m.Search
+	}
+	search.Polygon = m.Polygon
+
+	ids, err := (&Search{Client: t.Client, DB: t.DB}).contactIDs(m.GroupID, search)
+	if err != nil {
+		logs.Error(err)
+		return nil, err
+	}
+	if err = models.MissionStore(t.DB).AddContacts(models.MissionArgs{Mission: m}, ids); err != nil {
+		logs.Error(err)
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// reindex indexes again the contacts which were in the mission before the change and the ones which are in it now
+func (t *Mission) reindex(args models.MissionArgs, before []uint) error {
+	if t.Client == nil || args.Mission == nil {
+		return nil
+	}
+
+	after, err := models.MissionStore(t.DB).ContactIDs(args)
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return (&Search{Client: t.Client, DB: t.DB}).reindex(args.Mission.GroupID, append(before, after...))
+}
@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jinzhu/gorm"
 	"github.com/quorumsco/contacts/models"
 	//"github.com/quorumsco/elastic"
 	"github.com/quorumsco/logs"
@@ -18,6 +20,7 @@ import (
 // Search contains the search related methods and a gorm client
 type Search struct {
 	Client *elastic.Client
+	DB     *gorm.DB
 }
 
 type respID struct {
@@ -50,14 +53,332 @@ func (s *Search) Index(args models.ContactArgs, reply *models.ContactReply) erro
 	return nil
 }
 
+// reindex indexes again the contacts of a group given by IDs, after a change to their tags or missions
+func (s *Search) reindex(groupID uint, ids []uint) error {
+	var (
+		contactStore = models.ContactStore(s.DB)
+		done         = make(map[uint]bool)
+	)
+
+	for _, id := range ids {
+		if done[id] {
+			continue
+		}
+		done[id] = true
+
+		c, err := contactStore.First(models.ContactArgs{Contact: &models.Contact{ID: id, GroupID: groupID}})
+		if err != nil {
+			logs.Error(err)
+			return err
+		}
+		if c == nil {
+			continue
+		}
+		if err = s.Index(models.ContactArgs{Contact: c}, &models.ContactReply{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// contactIDs returns the IDs of the contacts of the group matching the search, as SearchContacts would find them
+func (s *Search) contactIDs(groupID uint, search *models.Search) ([]uint, error) {
+	var ids []uint
+
+	query, err := s.contactsQuery(groupID, search)
+	if err != nil {
+		return nil, err
+	}
+
+	searchResult, err := s.Client.Search().
+		Index("contacts").
+		FetchSource(false).
+		Query(query).
+		Size(10000000).
+		Do()
+	if err != nil {
+		return nil, err
+	}
+
+	if searchResult.Hits != nil {
+		for _, hit := range searchResult.Hits.Hits {
+			id, err := strconv.Atoi(hit.Id)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, uint(id))
+		}
+	}
+
+	return ids, nil
+}
+
+// contactsQuery returns the query selecting the contacts of the group matching the search
+func (s *Search) contactsQuery(groupID uint, search *models.Search) (elastic.Query, error) {
+	// the selection is always restricted to the given group
+	fields := append([]string{}, search.Fields...)
+	for len(fields) < 2 {
+		fields = append(fields, "all")
+	}
+	fields[0] = strconv.Itoa(int(groupID))
+	search.Fields = fields
+
+	sargs := models.SearchArgs{Search: search}
+	if err := s.inferFormTypes(sargs); err != nil {
+		return nil, err
+	}
+
+	var bq elastic.BoolQuery
+	if err := BuildQuery(sargs, &bq); err != nil {
+		return nil, err
+	}
+
+	if len(search.Polygon) > 0 {
+		filter := elastic.NewGeoPolygonFilter("location")
+		for _, point := range search.Polygon {
+			filter = filter.AddPoint(elastic.GeoPointFromLatLon(point.Lat, point.Lng))
+		}
+		return elastic.NewFilteredQuery(bq).Filter(filter), nil
+	}
+
+	return &bq, nil
+}
+
+// ActionMatrix returns via RPC a page of the contacts targeted by an action with the last status recorded on each,
+// and the conversion stats of the action, like Action.Matrix but from the contacts and facts indexes for the large actions.
+// Elasticsearch counts the targets and picks the last fact of each contact, only the page of contacts is fetched.
+func (s *Search) ActionMatrix(args models.ActionArgs, reply *models.ActionReply) error {
+	var (
+		actionStore = models.ActionStore(s.DB)
+		targets     = make(map[string]int)
+		inTargets   = make(map[uint]bool)
+		last        = make(map[uint]models.ActionContact)
+		ids         []string
+	)
+
+	a, err := actionStore.Brief(args)
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+	if a == nil {
+		return errors.New("action matrix: action not found")
+	}
+
+	// le dernier fait de chaque contact pour l'action
+	reached, err := s.lastFacts(a)
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+	for _, row := range reached {
+		last[row.ContactID] = row
+		ids = append(ids, strconv.Itoa(int(row.ContactID)))
+	}
+
+	// les cibles de l'action, les contacts atteints en dehors d'elles le sont aussi
+	var query elastic.Query = elastic.NewIdsQuery("contact").Ids(ids...)
+	if a.Search != nil {
+		targetQuery, err := s.contactsQuery(a.GroupID, a.Search)
+		if err != nil {
+			logs.Error(err)
+			return err
+		}
+		if targets, inTargets, err = s.countTargets(targetQuery, ids); err != nil {
+			logs.Error(err)
+			return err
+		}
+		query = elastic.NewBoolQuery().Should(targetQuery, query)
+	}
+	for _, row := range reached {
+		if !inTargets[row.ContactID] {
+			targets[row.PollingStation]++
+		}
+	}
+
+	reply.Stats = models.SummarizeActionCounts(a.ID, targets, reached)
+
+	if a.Search == nil && len(ids) == 0 {
+		return nil
+	}
+
+	// la page des contacts, par nom
+	size := args.Size
+	if size <= 0 {
+		size = models.ActionPageSize
+	}
+	searchResult, err := s.Client.Search().
+		Index("contacts").
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include("firstname", "surname", "address.pollingstation")).
+		Query(query).
+		Sort("surname", true).
+		Sort("firstname", true).
+		From(args.From).
+		Size(size).
+		Do()
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	if searchResult.Hits != nil {
+		for _, hit := range searchResult.Hits.Hits {
+			var c models.Contact
+			if err = json.Unmarshal(*hit.Source, &c); err != nil {
+				logs.Error(err)
+				return err
+			}
+			id, err := strconv.Atoi(hit.Id)
+			if err != nil {
+				logs.Error(err)
+				return err
+			}
+			row, ok := last[uint(id)]
+			if !ok {
+				row = models.ActionContact{ContactID: uint(id)}
+			}
+			row.Firstname, row.Surname, row.PollingStation = c.Firstname, c.Surname, c.Address.PollingStation
+			reply.Contacts = append(reply.Contacts, row)
+		}
+	}
+
+	// the authors of the facts recorded before they were kept are looked up in the audit log, for the page only
+	if err = actionStore.Attribute(models.ActionArgs{Action: a}, reply.Contacts); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// lastFacts returns the last fact recorded on each contact for an action, picked by elasticsearch
+func (s *Search) lastFacts(a *models.Action) ([]models.ActionContact, error) {
+	var rows []models.ActionContact
+
+	last := elastic.NewTopHitsAggregation().
+		Size(1).
+		Sort("occurred_at", false).
+		Sort("id", false).
+		FetchSourceContext(elastic.NewFetchSourceContext(true).Include("id", "contact_id", "status", "user_id", "occurred_at", "contact.address.pollingstation"))
+	byContact := elastic.NewTermsAggregation().Field("contact_id").Size(0).SubAggregation("last", last)
+
+	query := elastic.NewBoolQuery().Must(elastic.NewTermQuery("group_id", a.GroupID), elastic.NewTermQuery("action_id", a.ID))
+	searchResult, err := s.Client.Search().
+		Index("facts").
+		Query(&query).
+		Size(0).
+		Aggregation("contacts", byContact).
+		Do()
+	if err != nil {
+		return nil, err
+	}
+
+	agg, found := searchResult.Aggregations.Terms("contacts")
+	if !found {
+		return nil, nil
+	}
+	for _, bucket := range agg.Buckets {
+		hits, found := bucket.TopHits("last")
+		if !found || hits.Hits == nil || len(hits.Hits.Hits) == 0 {
+			continue
+		}
+		var f models.Fact
+		if err = json.Unmarshal(*hits.Hits.Hits[0].Source, &f); err != nil {
+			return nil, err
+		}
+		rows = append(rows, models.ActionContact{ContactID: f.ContactID, PollingStation: f.Contact.Address.PollingStation, FactID: f.ID, Status: f.Status, UserID: f.UserID, Date: f.OccurredAt})
+	}
+
+	return rows, nil
+}
+
+// countTargets counts by polling station the contacts matching the query of the targets of an action,
+// and returns which of the contacts given by IDs are among them
+func (s *Search) countTargets(query elastic.Query, ids []string) (map[string]int, map[uint]bool, error) {
+	var (
+		targets   = make(map[string]int)
+		inTargets = make(map[uint]bool)
+	)
+
+	searchResult, err := s.Client.Search().
+		Index("contacts").
+		Query(query).
+		Size(0).
+		Aggregation("stations", elastic.NewTermsAggregation().Field("address.PollingStation").Size(0)).
+		Aggregation("nostation", elastic.NewMissingAggregation().Field("address.PollingStation")).
+		Do()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if agg, found := searchResult.Aggregations.Terms("stations"); found {
+		for _, bucket := range agg.Buckets {
+			targets[fmt.Sprint(bucket.Key)] += int(bucket.DocCount)
+		}
+	}
+	if agg, found := searchResult.Aggregations.Missing("nostation"); found && agg.DocCount > 0 {
+		targets[""] += int(agg.DocCount)
+	}
+
+	if len(ids) == 0 {
+		return targets, inTargets, nil
+	}
+
+	reached := elastic.NewBoolQuery().Must(query, elastic.NewIdsQuery("contact").Ids(ids...))
+	searchResult, err = s.Client.Search().
+		Index("contacts").
+		FetchSource(false).
+		Query(&reached).
+		Size(len(ids)).
+		Do()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if searchResult.Hits != nil {
+		for _, hit := range searchResult.Hits.Hits {
+			id, err := strconv.Atoi(hit.Id)
+			if err != nil {
+				return nil, nil, err
+			}
+			inTargets[uint(id)] = true
+		}
+	}
+
+	return targets, inTargets, nil
+}
+
+// flagLeases sets on the contacts found the lease of the building being visited, see models.Lease
+func (s *Search) flagLeases(args models.SearchArgs, contacts []models.Contact) error {
+	if s.DB == nil || len(contacts) == 0 || len(args.Search.Fields) == 0 {
+		return nil
+	}
+
+	groupID, err := strconv.Atoi(args.Search.Fields[0])
+	if err != nil {
+		return err
+	}
+
+	return models.LeaseStore(s.DB).Flag(uint(groupID), contacts)
+}
+
 // Index indexes a contact into elasticsearch
 func (s *Search) IndexFact(args models.FactArgs, reply *models.FactReply) error {
 	args.Fact.Contact.Address.Location = fmt.Sprintf("%s,%s", args.Fact.Contact.Address.Latitude, args.Fact.Contact.Address.Longitude)
-	_, err := s.Client.Index().
+	if args.Fact.Latitude != nil && args.Fact.Longitude != nil {
+		args.Fact.Location = fmt.Sprintf("%f,%f", *args.Fact.Latitude, *args.Fact.Longitude)
+	}
+
+	indexService := s.Client.Index().
 		Index("facts").
 		Type("fact").
-		BodyJson(args.Fact).
-		Do()
+		BodyJson(args.Fact)
+	// indexing again a fact replaces it
+	if args.Fact.ID != 0 {
+		indexService.Id(strconv.Itoa(int(args.Fact.ID)))
+	}
+	_, err := indexService.Do()
 	if err != nil {
 		logs.Critical(err)
 		return err
@@ -80,6 +401,63 @@ func (s *Search) IndexAction(args models.ActionArgs, reply *models.ActionReply)
 	return nil
 }
 
+// ReindexFacts indexes again every fact of the group by its ID, the facts indexed before it was used having a random one
+// which would leave a copy of them, and returns the number of facts indexed via RPC
+func (s *Search) ReindexFacts(args models.FactArgs, reply *models.FactReply) error {
+	if args.Fact == nil || args.Fact.GroupID == 0 {
+		logs.Error("no group given")
+		return errors.New("no group given")
+	}
+
+	var (
+		factStore = models.FactStore(s.DB)
+		afterID   uint
+	)
+
+	if _, err := s.Client.DeleteByQuery().Index("facts").Query(elastic.NewTermQuery("group_id", args.Fact.GroupID)).Do(); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	for {
+		facts, err := factStore.FindFrom(args, afterID, 500)
+		if err != nil {
+			logs.Error(err)
+			return err
+		}
+		if len(facts) == 0 {
+			return nil
+		}
+		for i := range facts {
+			if err = s.IndexFact(models.FactArgs{Fact: &facts[i]}, &models.FactReply{}); err != nil {
+				return err
+			}
+			reply.Total++
+		}
+		afterID = facts[len(facts)-1].ID
+	}
+}
+
+// UnIndexFact unindexes a fact from elasticsearch
+func (s *Search) UnIndexFact(args models.FactArgs, reply *models.FactReply) error {
+	if args.Fact == nil || args.Fact.ID == 0 {
+		logs.Error("id is nil")
+		return errors.New("id is nil")
+	}
+
+	_, err := s.Client.Delete().
+		Index("facts").
+		Type("fact").
+		Id(strconv.Itoa(int(args.Fact.ID))).
+		Do()
+	if err != nil {
+		logs.Critical(err)
+		return err
+	}
+
+	return nil
+}
+
 // UnIndex unindexes a contact from elasticsearch
 func (s *Search) UnIndex(args models.ContactArgs, reply *models.ContactReply) error {
 	id := strconv.Itoa(int(args.Contact.ID))
@@ -220,6 +598,12 @@ func BuildQuery(args models.SearchArgs, bq *elastic.BoolQuery) error {
 			Query = Query.Field("married_name")
 			Query = Query.Field("address.street")
 			Query = Query.Field("address.city")
+			Query = Query.Field("methods.value")
+			Query = Query.Field("addresses.address.street")
+			Query = Query.Field("addresses.address.city")
+		} else if args.Search.Fields[1] == "methods" {
+			//tous les emails et numéros de téléphone du contact
+			Query = Query.Field("methods.value")
 		} else if args.Search.Fields[1] == "city&name" {
 			//champs dans lesquels chercher
 			Query = Query.Field("address.city")
@@ -249,6 +633,15 @@ func BuildQuery(args models.SearchArgs, bq *elastic.BoolQuery) error {
 	// filtre la recherche sur un groupe en particulier !!!! pas d'authorisation nécessaire !!!!
 	*bq = bq.Must(elastic.NewTermQuery("group_id", args.Search.Fields[0]))
 
+	// contacts joignables par les canaux demandés ("can be emailed"...)
+	for _, channel := range args.Search.Consents {
+		*bq = bq.Must(elastic.NewTermQuery("consents."+channel, true))
+	}
+
+	// tags et missions : l'un des IDs, tous les IDs, aucun des IDs
+	buildQueryIDs(bq, "tag_ids", args.Search.Tags, args.Search.AllTags, args.Search.ExcludeTags)
+	buildQueryIDs(bq, "mission_ids", args.Search.Missions, args.Search.AllMissions, args.Search.ExcludeMissions)
+
 	// contrôle si on est en recherche simple (mobile) ou avancée (desktop)
 	// si recherche avancée cad plus de 3 paramêtres dans Fields
 	if len(args.Search.Fields) > 4 {
@@ -433,6 +826,69 @@ for Date -> interfaceSlice_form[]=["DATE",123,true,645,"23/12/2015","27/12/2016"
 for Date -> interfaceSlice_form[]=["DATE",123,false,645,"23/12/2015","27/12/2016"]
 */
 
+// buildQueryIDs filters the contacts having one of the IDs of any, all the IDs of all and none of the IDs of exclude in the field
+func buildQueryIDs(bq *elastic.BoolQuery, field string, any []uint, all []uint, exclude []uint) {
+	terms := func(ids []uint) []interface{} {
+		var values []interface{}
+		for _, id := range ids {
+			values = append(values, id)
+		}
+		return values
+	}
+
+	if len(any) > 0 {
+		*bq = bq.Must(elastic.NewTermsQuery(field, terms(any)...))
+	}
+	for _, id := range all {
+		*bq = bq.Must(elastic.NewTermQuery(field, id))
+	}
+	if len(exclude) > 0 {
+		*bq = bq.MustNot(elastic.NewTermsQuery(field, terms(exclude)...))
+	}
+}
+
+// inferFormTypes completes the form filters given without their type, "123/true" becoming "RADIO/123/true",
+// with the type of the question in the forms of the group
+func (s *Search) inferFormTypes(args models.SearchArgs) error {
+	if s.DB == nil || len(args.Search.Fields) <= 11 {
+		return nil
+	}
+
+	groupID, err := strconv.Atoi(args.Search.Fields[0])
+	if err != nil {
+		return nil
+	}
+
+	var ids []uint
+	for _, field := range args.Search.Fields[11:] {
+		if id, err := strconv.Atoi(strings.Split(field, "/")[0]); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	types, err := models.FormStore(s.DB).QuestionTypes(models.FormArgs{GroupID: uint(groupID)}, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := 11; i < len(args.Search.Fields); i++ {
+		id, err := strconv.Atoi(strings.Split(args.Search.Fields[i], "/")[0])
+		if err != nil {
+			continue
+		}
+		t, ok := types[uint(id)]
+		if !ok {
+			return fmt.Errorf("search: form question %d not found", id)
+		}
+		args.Search.Fields[i] = t + "/" + args.Search.Fields[i]
+	}
+
+	return nil
+}
+
 // method dedicated for the Forms part of the query
 func BuildQueryForm(args models.SearchArgs, bq *elastic.BoolQuery) error {
 	//pour chacun des forms où l'on souhaite faire une requête
@@ -724,6 +1180,11 @@ func (s *Search) SearchContacts(args models.SearchArgs, reply *models.SearchRepl
 		args.Search.Fields[3] = "0"
 	}
 
+	if err := s.inferFormTypes(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
 	var bq elastic.BoolQuery
 	err := BuildQuery(args, &bq)
 	if err != nil {
@@ -1055,6 +1516,12 @@ func (s *Search) SearchContacts(args models.SearchArgs, reply *models.SearchRepl
 		reply.Contacts = nil
 	}
 
+	// les bâtiments en cours de visite sont signalés
+	if err = s.flagLeases(args, reply.Contacts); err != nil {
+		logs.Error(err)
+		return err
+	}
+
 	// traitement aggrégations -> address aggs -----------------
 	if args.Search.Fields[1] == "address_aggreg" || args.Search.Fields[1] == "address_aggreg_first_part" {
 		logs.Debug("----------------------ENTER FIRST PART----------------------------")
@@ -1498,6 +1965,11 @@ func (s *Search) KpiContacts(args models.SearchArgs, reply *models.SearchReply)
 	logs.Debug("args.Search.Query:%s", args.Search.Query)
 	logs.Debug("args.Search.Fields:%s", args.Search.Fields)
 
+	if err := s.inferFormTypes(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
 	var bq elastic.BoolQuery
 
 	//construction de la query - commun avec findcontacts----------
@@ -1539,6 +2011,11 @@ func (s *Search) KpiContacts(args models.SearchArgs, reply *models.SearchReply)
 	aggreg_kpi_without_email := elastic.NewMissingAggregation().Field("mail")
 	aggreg_kpi_without_tel := elastic.NewMissingAggregation().Field("phone")
 
+	aggreg_kpi_tags := elastic.NewTermsAggregation().Field("tag_ids").Size(500)
+	aggreg_kpi_tags_missing := elastic.NewMissingAggregation().Field("tag_ids")
+	aggreg_kpi_missions := elastic.NewTermsAggregation().Field("mission_ids").Size(500)
+	aggreg_kpi_missions_missing := elastic.NewMissingAggregation().Field("mission_ids")
+
 	//--------------------------------------------------------------------------------------------------------------------
 
 	searchService := s.Client.Search().
@@ -1560,7 +2037,11 @@ func (s *Search) KpiContacts(args models.SearchArgs, reply *models.SearchReply)
 		Aggregation("6_aggreg", aggreg_kpi_birthdate[6].(elastic.DateRangeAggregation)).
 		// aggregation email par
 		Aggregation("contacts_sans_email_aggreg", aggreg_kpi_without_email).
-		Aggregation("contacts_sans_tel_aggreg", aggreg_kpi_without_tel)
+		Aggregation("contacts_sans_tel_aggreg", aggreg_kpi_without_tel).
+		Aggregation("tags_aggreg", aggreg_kpi_tags).
+		Aggregation("tags_missing_aggreg", aggreg_kpi_tags_missing).
+		Aggregation("missions_aggreg", aggreg_kpi_missions).
+		Aggregation("missions_missing_aggreg", aggreg_kpi_missions_missing)
 
 	Filter := elastic.NewGeoPolygonFilter("location")
 	if len(args.Search.Polygon) > 0 {
@@ -1625,6 +2106,11 @@ func (s *Search) KpiContacts(args models.SearchArgs, reply *models.SearchReply)
 	contacts_sans_email_agg, found13 := searchResult.Aggregations.Missing("contacts_sans_email_aggreg")
 	contacts_sans_tel_agg, found14 := searchResult.Aggregations.Missing("contacts_sans_tel_aggreg")
 
+	tags_agg, found15 := searchResult.Aggregations.Terms("tags_aggreg")
+	tags_missing_agg, found16 := searchResult.Aggregations.Missing("tags_missing_aggreg")
+	missions_agg, found17 := searchResult.Aggregations.Terms("missions_aggreg")
+	missions_missing_agg, found18 := searchResult.Aggregations.Missing("missions_missing_aggreg")
+
 	if !found {
 		logs.Error("we sould have a terms aggregation called %q", "gender_aggreg")
 	}
@@ -1673,6 +2159,18 @@ func (s *Search) KpiContacts(args models.SearchArgs, reply *models.SearchReply)
 	if !found14 {
 		logs.Error("we sould have a terms aggregation called %q", "contacts_sans_tel_aggreg")
 	}
+	if !found15 {
+		logs.Error("we sould have a terms aggregation called %q", "tags_aggreg")
+	}
+	if !found16 {
+		logs.Error("we sould have a terms aggregation called %q", "tags_missing_aggreg")
+	}
+	if !found17 {
+		logs.Error("we sould have a terms aggregation called %q", "missions_aggreg")
+	}
+	if !found18 {
+		logs.Error("we sould have a terms aggregation called %q", "missions_missing_aggreg")
+	}
 
 	if searchResult.Aggregations != nil {
 		var tab_kpiAtom models.KpiAggs
@@ -1855,6 +2353,36 @@ func (s *Search) KpiContacts(args models.SearchArgs, reply *models.SearchReply)
 		reply.Kpi = append(reply.Kpi, tab_kpiAtom)
 		tab_kpiAtom = models.KpiAggs{}
 
+		// ---- stockage réponses pour tags_aggreg et missions_aggreg, par ID -----------------------
+		for _, agg := range []struct {
+			terms   *elastic.AggregationBucketKeyItems
+			missing *elastic.AggregationSingleBucket
+		}{{tags_agg, tags_missing_agg}, {missions_agg, missions_missing_agg}} {
+			if agg.terms != nil {
+				for _, bucket := range agg.terms.Buckets {
+					var kpiAtom models.KpiReply
+					kpiAtom.Key = strconv.FormatFloat(bucket.Key.(float64), 'f', -1, 64)
+					kpiAtom.Doc_count = bucket.DocCount
+					tab_kpiAtom.KpiReplies = append(tab_kpiAtom.KpiReplies, kpiAtom)
+				}
+			}
+			if agg.missing != nil && agg.missing.DocCount > 0 {
+				tab_kpiAtom.KpiReplies = append(tab_kpiAtom.KpiReplies, models.KpiReply{Key: "missing", Doc_count: agg.missing.DocCount})
+			}
+			reply.Kpi = append(reply.Kpi, tab_kpiAtom)
+			tab_kpiAtom = models.KpiAggs{}
+		}
+
+		// ---- progression des missions filtrées, ajoutée en dernier -----------------------
+		if len(args.Search.Missions) > 0 && s.DB != nil {
+			progress, err := s.missionProgress(args)
+			if err != nil {
+				logs.Error(err)
+				return err
+			}
+			reply.Kpi = append(reply.Kpi, progress)
+		}
+
 		// ---------------------------------------------------------------------
 
 	} else {
@@ -1863,6 +2391,48 @@ func (s *Search) KpiContacts(args models.SearchArgs, reply *models.SearchReply)
 	return nil
 }
 
+// missionProgress sums up the progress of the missions the search filters on, the contacts being counted
+// as "contacts", "visited", "answered" and "status:" followed by the status of their last fact
+func (s *Search) missionProgress(args models.SearchArgs) (models.KpiAggs, error) {
+	var (
+		kpi      models.KpiAggs
+		counts   = make(map[string]int64)
+		keys     = []string{"contacts", "visited", "answered"}
+		groupID  int
+		err      error
+		statuses []string
+	)
+
+	if len(args.Search.Fields) > 0 {
+		if groupID, err = strconv.Atoi(args.Search.Fields[0]); err != nil {
+			return kpi, err
+		}
+	}
+
+	for _, id := range args.Search.Missions {
+		p, err := models.MissionStore(s.DB).Progress(models.MissionArgs{Mission: &models.Mission{ID: id, GroupID: uint(groupID)}})
+		if err != nil {
+			return kpi, err
+		}
+		counts["contacts"] += int64(p.Contacts)
+		counts["visited"] += int64(p.Visited)
+		counts["answered"] += int64(p.Answered)
+		for status, n := range p.Statuses {
+			if _, ok := counts["status:"+status]; !ok {
+				statuses = append(statuses, "status:"+status)
+			}
+			counts["status:"+status] += int64(n)
+		}
+	}
+
+	sort.Strings(statuses)
+	for _, key := range append(keys, statuses...) {
+		kpi.KpiReplies = append(kpi.KpiReplies, models.KpiReply{Key: key, Doc_count: counts[key]})
+	}
+
+	return kpi, nil
+}
+
 func (s *Search) AggregationContacts(args models.SearchArgs, reply *models.SearchReply) error {
 	// Groups are:
 	// 1. user_id
@@ -2038,24 +2608,68 @@ func (s *Search) AggregationContacts(args models.SearchArgs, reply *models.Searc
 			elastic.NewTermsAggregation().Field("formdatas.data.strictdata")))
 	aggreg_presence_missing := elastic.NewFilterAggregation().Filter(elastic.NewNotFilter(elastic.NewTermQuery("formdatas.form_id", presenceFormId)))
 
+	// 4. tag, 5. mission
+	aggreg_tag := elastic.NewTermsAggregation().Field("tag_ids").Size(500)
+	aggreg_tag_missing := elastic.NewMissingAggregation().Field("tag_ids")
+	aggreg_mission := elastic.NewTermsAggregation().Field("mission_ids").Size(500)
+	aggreg_mission_missing := elastic.NewMissingAggregation().Field("mission_ids")
+
 	// Want these to all be sub aggregations, but for each we need two (missing/not missing)
-	// tier order is user, date, presence; build up from bottom
-	// bottom sub aggregation - presence
-	agg_presence_sub := aggreg_presence
-	agg_presence_m_sub := aggreg_presence_missing
-	// date
-	agg_date_sub := aggreg_date.SubAggregation("presence_agg", agg_presence_sub).SubAggregation("presence_m_agg", agg_presence_m_sub)
-	agg_date_m_sub := aggreg_date_missing.SubAggregation("presence_agg", agg_presence_sub).SubAggregation("presence_m_agg", agg_presence_m_sub)
-	// top sub aggregation - user
-	agg_user_sub := aggreg_user_id.SubAggregation("date_agg", agg_date_sub).SubAggregation("date_m_agg", agg_date_m_sub)
-	agg_user_m_sub := aggreg_user_id_missing.SubAggregation("date_agg", agg_date_sub).SubAggregation("date_m_agg", agg_date_m_sub)
-
-	// want all of these to be sub filters; root with user/user missing
+	// tier order is user, date, presence unless other levels are asked for; build up from bottom
+	// presence is nested in the formdatas, so it can only be the bottom one
+	levels := args.Search.Levels
+	if len(levels) == 0 {
+		levels = []string{"user", "date", "presence"}
+	}
+	seen := make(map[string]bool)
+	for i, level := range levels {
+		if seen[level] || (level == "presence" && i != len(levels)-1) {
+			return fmt.Errorf("%q can't be a level of the aggregation here", level)
+		}
+		seen[level] = true
+	}
+
+	var subs map[string]elastic.Aggregation
+	for i := len(levels) - 1; i >= 0; i-- {
+		var agg, missing elastic.Aggregation
+
+		switch levels[i] {
+		case "user":
+			for name, sub := range subs {
+				aggreg_user_id, aggreg_user_id_missing = aggreg_user_id.SubAggregation(name, sub), aggreg_user_id_missing.SubAggregation(name, sub)
+			}
+			agg, missing = aggreg_user_id, aggreg_user_id_missing
+		case "date":
+			for name, sub := range subs {
+				aggreg_date, aggreg_date_missing = aggreg_date.SubAggregation(name, sub), aggreg_date_missing.SubAggregation(name, sub)
+			}
+			agg, missing = aggreg_date, aggreg_date_missing
+		case "presence":
+			agg, missing = aggreg_presence, aggreg_presence_missing
+		case "tag":
+			for name, sub := range subs {
+				aggreg_tag, aggreg_tag_missing = aggreg_tag.SubAggregation(name, sub), aggreg_tag_missing.SubAggregation(name, sub)
+			}
+			agg, missing = aggreg_tag, aggreg_tag_missing
+		case "mission":
+			for name, sub := range subs {
+				aggreg_mission, aggreg_mission_missing = aggreg_mission.SubAggregation(name, sub), aggreg_mission_missing.SubAggregation(name, sub)
+			}
+			agg, missing = aggreg_mission, aggreg_mission_missing
+		default:
+			return fmt.Errorf("%q is not a valid level of the aggregation", levels[i])
+		}
+
+		subs = map[string]elastic.Aggregation{levels[i] + "_agg": agg, levels[i] + "_m_agg": missing}
+	}
+
+	// want all of these to be sub filters; root with the top level and its missing
 	searchService := s.Client.Search().
 		Index("contacts").
-		Size(0).
-		Aggregation("user_agg", agg_user_sub).
-		Aggregation("user_m_agg", agg_user_m_sub)
+		Size(0)
+	for name, agg := range subs {
+		searchService = searchService.Aggregation(name, agg)
+	}
 
 	/* Filter by location */
 	// northeast lat, lng (top left) should be args.Search.Fields[4] and [5] (after group_id, presenceFormId and date)
@@ -2083,7 +2697,7 @@ func (s *Search) AggregationContacts(args models.SearchArgs, reply *models.Searc
 	// For each entry (row), the first n entries specify the aggregation (for example, userId, lastchange, and presence), and the
 	// last entry is a string of the integer count
 	// This is a convenient way to store what is effectively a table
-	ParseElasticAggregationLevels(searchResult.Aggregations, reply, []string{"user", "date", "presence"}, []string{})
+	ParseElasticAggregationLevels(searchResult.Aggregations, reply, levels, []string{})
 
 	return nil
 }
@@ -2338,6 +2952,11 @@ func (s *Search) LocationSummaryContactsGeoHashWithSearchFilter(args models.Sear
 	logs.Debug("LocationSummaryContactsGeoHashWithSearchFilter")
 	logs.Debug("args.Search.Query:%s", args.Search.Query)
 	logs.Debug("args.Search.Fields:%s", args.Search.Fields)
+	if err := s.inferFormTypes(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
 	var bq elastic.BoolQuery
 
 	//construction de la query - commun avec findcontacts----------
@@ -2827,6 +3446,8 @@ func ParseElasticAggregationLevels(aggs elastic.Aggregations, reply *models.Sear
 			return ParseDates(aggs, reply, order, aggAccumulator)
 		case "presence":
 			return ParsePresences(aggs, reply, order, aggAccumulator)
+		case "tag", "mission":
+			return ParseIDs(aggs, reply, level, order, aggAccumulator)
 		default:
 			logs.Error("%q is not a valid level to parse", level)
 			return nil // TODO - should be an error
@@ -2873,6 +3494,46 @@ func ParseUsers(aggs elastic.Aggregations, reply *models.SearchReply, order []st
 	return nil
 }
 
+// ParseIDs parses the tag or mission level, whose keys are IDs
+func ParseIDs(aggs elastic.Aggregations, reply *models.SearchReply, level string, order []string, aggAccumulator []string) error {
+	id_key := level + "_agg"
+	id_missing_key := level + "_m_agg"
+
+	var ac []string
+
+	id_agg, found_id := aggs.Terms(id_key)
+	if !found_id {
+		logs.Error("we should have a terms aggregation called %q", id_key)
+		return nil
+	}
+
+	for _, bucket := range id_agg.Buckets {
+		ac = append(append([]string{}, aggAccumulator...), strconv.FormatFloat(bucket.Key.(float64), 'f', -1, 64))
+		if len(order) <= 0 {
+			ac = append(ac, strconv.FormatInt(bucket.DocCount, 10))
+			reply.Aggregation = append(reply.Aggregation, ac)
+		} else {
+			ParseElasticAggregationLevels(bucket.Aggregations, reply, order, ac)
+		}
+	}
+
+	id_m_agg, found_id_m := aggs.Missing(id_missing_key)
+	if !found_id_m {
+		logs.Error("we should have a missing aggregation called %q", id_missing_key)
+		return nil
+	}
+
+	ac = append(append([]string{}, aggAccumulator...), "N/A")
+	if len(order) <= 0 {
+		ac = append(ac, strconv.FormatInt(id_m_agg.DocCount, 10))
+		reply.Aggregation = append(reply.Aggregation, ac)
+	} else {
+		ParseElasticAggregationLevels(id_m_agg.Aggregations, reply, order, ac)
+	}
+
+	return nil
+}
+
 func ParseDates(aggs elastic.Aggregations, reply *models.SearchReply, order []string, aggAccumulator []string) error {
 	date_key := "date_agg"
 	date_missing_key := "date_m_agg"
@@ -3198,6 +3859,12 @@ func (s *Search) SearchContactsGeoloc(args models.SearchArgs, reply *models.Sear
 		}
 		//logs.Debug(reply.Contacts)
 
+		// les bâtiments en cours de visite sont signalés
+		if err = s.flagLeases(args, reply.Contacts); err != nil {
+			logs.Error(err)
+			return err
+		}
+
 	} else {
 		reply.Contacts = nil
 	}
@@ -3252,6 +3919,104 @@ func (s *Search) SearchIDViaGeoPolygon(args models.SearchArgs, reply *models.Sea
 	return nil
 }
 
+// SearchFacts searches the facts of a group matching args.Search and returns via RPC a page of them, the latest first,
+// and how many occurred by interval of time and by status
+func (s *Search) SearchFacts(args models.FactArgs, reply *models.FactReply) error {
+	search := args.Search
+	if search == nil {
+		return errors.New("search facts: no search given")
+	}
+
+	interval := search.Interval
+	if interval == "" {
+		interval = "day"
+	}
+	valid := false
+	for _, i := range models.FactIntervals {
+		valid = valid || i == interval
+	}
+	if !valid {
+		return fmt.Errorf("search facts: unknown interval %q", interval)
+	}
+	size := search.Size
+	if size <= 0 {
+		size = models.FactPageSize
+	}
+
+	bq := elastic.NewBoolQuery()
+	bq = bq.Must(elastic.NewTermQuery("group_id", search.GroupID))
+	if search.Start != nil || search.End != nil {
+		rangeQuery := elastic.NewRangeQuery("occurred_at")
+		if search.Start != nil {
+			rangeQuery = rangeQuery.Gte(*search.Start)
+		}
+		if search.End != nil {
+			rangeQuery = rangeQuery.Lte(*search.End)
+		}
+		bq = bq.Must(rangeQuery)
+	}
+	for field, values := range map[string][]string{"type": search.Types, "status": search.Statuses} {
+		if len(values) > 0 {
+			var terms []interface{}
+			for _, v := range values {
+				terms = append(terms, v)
+			}
+			bq = bq.Must(elastic.NewTermsQuery(field, terms...))
+		}
+	}
+	buildQueryIDs(&bq, "action_id", search.ActionIDs, nil, nil)
+	buildQueryIDs(&bq, "user_id", search.UserIDs, nil, nil)
+	buildQueryIDs(&bq, "contact_id", search.ContactIDs, nil, nil)
+	if search.Suspicious != nil {
+		bq = bq.Must(elastic.NewTermQuery("suspicious", *search.Suspicious))
+	}
+
+	aggreg_date := elastic.NewDateHistogramAggregation().Field("occurred_at").Interval(interval).MinDocCount(0)
+	aggreg_date = aggreg_date.SubAggregation("status_agg", elastic.NewTermsAggregation().Field("status").Size(100))
+
+	searchResult, err := s.Client.Search().
+		Index("facts").
+		Query(&bq).
+		Sort("occurred_at", false).
+		From(search.From).
+		Size(size).
+		Aggregation("date_agg", aggreg_date).
+		Do()
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	if searchResult.Hits != nil {
+		reply.Total = searchResult.Hits.TotalHits
+		for _, hit := range searchResult.Hits.Hits {
+			var f models.Fact
+			if err = json.Unmarshal(*hit.Source, &f); err != nil {
+				logs.Error(err)
+				return err
+			}
+			reply.Facts = append(reply.Facts, f)
+		}
+	}
+
+	date_agg, found := searchResult.Aggregations.DateHistogram("date_agg")
+	if !found {
+		logs.Error("we should have a date histogram aggregation called %q", "date_agg")
+		return nil
+	}
+	for _, bucket := range date_agg.Buckets {
+		b := models.FactBucket{Date: time.Unix(0, bucket.Key*int64(time.Millisecond)).UTC(), Count: bucket.DocCount, Statuses: make(map[string]int64)}
+		if status_agg, found := bucket.Terms("status_agg"); found {
+			for _, status := range status_agg.Buckets {
+				b.Statuses[fmt.Sprint(status.Key)] = status.DocCount
+			}
+		}
+		reply.Histogram = append(reply.Histogram, b)
+	}
+
+	return nil
+}
+
 // RetrieveContacts performs a match_all query to elasticsearch and returns the results via RPC
 func (s *Search) RetrieveContacts(args models.SearchArgs, reply *models.SearchReply) error {
 	Query := elastic.NewFilteredQuery(elastic.NewMatchAllQuery())
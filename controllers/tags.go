@@ -5,14 +5,16 @@ import (
 	"github.com/jinzhu/gorm"
 	"github.com/quorumsco/contacts/models"
 	"github.com/quorumsco/logs"
+	elastic "gopkg.in/olivere/elastic.v2"
 )
 
-// Tags contains the tag related methods and a gorm client
+// Tags contains the tag related methods, a gorm client and an elasticsearch client
 type Tag struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Client *elastic.Client
 }
 
-// RetrieveCollection calls the TagSQL Find method and returns the results via RPC
+// RetrieveCollection calls the TagSQL Find method and returns the tags of a contact, or the catalogue of the group, via RPC
 func (t *Tag) RetrieveCollection(args models.TagArgs, reply *models.TagReply) error {
 	var (
 		err error
@@ -44,10 +46,87 @@ func (t *Tag) Create(args models.TagArgs, reply *models.TagReply) error {
 
 	reply.Tag = args.Tag
 
+	return t.reindex(args.GroupID, args.ContactID)
+}
+
+// Update calls the TagSQL Save method and returns the results via RPC
+func (t *Tag) Update(args models.TagArgs, reply *models.TagReply) error {
+	var (
+		err error
+
+		tagStore = models.TagStore(t.DB)
+	)
+
+	if err = tagStore.Save(args.Tag, args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	reply.Tag = args.Tag
+
+	return t.reindex(args.GroupID, args.ContactID)
+}
+
+// Rename calls the TagSQL Rename method and returns the renamed tag via RPC
+func (t *Tag) Rename(args models.TagArgs, reply *models.TagReply) error {
+	var (
+		err error
+
+		tagStore = models.TagStore(t.DB)
+	)
+
+	if err = tagStore.Rename(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	reply.Tag = args.Tag
+
+	return nil
+}
+
+// Merge calls the TagSQL Merge method and returns the tag the others were merged into via RPC
+func (t *Tag) Merge(args models.TagArgs, reply *models.TagReply) error {
+	var (
+		err error
+
+		tagStore = models.TagStore(t.DB)
+	)
+
+	// the contacts of the merged tags are indexed again with the tag they now have
+	ids, err := tagStore.ContactIDs(models.TagArgs{GroupID: args.GroupID, TagIDs: args.TagIDs})
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	if err = tagStore.Merge(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	reply.Tag = args.Tag
+
+	return t.reindex(args.GroupID, ids...)
+}
+
+// Usage calls the TagSQL Usage method and returns the number of contacts by tag via RPC
+func (t *Tag) Usage(args models.TagArgs, reply *models.TagReply) error {
+	var (
+		err error
+
+		tagStore = models.TagStore(t.DB)
+	)
+
+	if reply.Usage, err = tagStore.Usage(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
 	return nil
 }
 
-// Delete calls the TagSQL Delete method and returns the results via RPC
+// Delete calls the TagSQL Delete method, which removes the tag from a contact or from the whole group, and returns the results via RPC
 func (t *Tag) Delete(args models.TagArgs, reply *models.TagReply) error {
 	var (
 		err error
@@ -55,10 +134,27 @@ func (t *Tag) Delete(args models.TagArgs, reply *models.TagReply) error {
 		tagStore = models.TagStore(t.DB)
 	)
 
+	ids := []uint{args.ContactID}
+	if args.ContactID == 0 && args.Tag != nil {
+		if ids, err = tagStore.ContactIDs(models.TagArgs{GroupID: args.GroupID, Tag: args.Tag}); err != nil {
+			logs.Error(err)
+			return err
+		}
+	}
+
 	if err = tagStore.Delete(args.Tag, args); err != nil {
 		logs.Debug(err)
 		return err
 	}
 
-	return nil
+	return t.reindex(args.GroupID, ids...)
+}
+
+// reindex indexes again the contacts whose tags changed
+func (t *Tag) reindex(groupID uint, ids ...uint) error {
+	if t.Client == nil || len(ids) == 0 || ids[0] == 0 {
+		return nil
+	}
+
+	return (&Search{Client: t.Client, DB: t.DB}).reindex(groupID, ids)
 }
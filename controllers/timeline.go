@@ -0,0 +1,28 @@
+// Bundle of functions managing the CRUD and the elasticsearch engine
+package controllers
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/quorumsco/contacts/models"
+	"github.com/quorumsco/logs"
+)
+
+// Timeline contains the timeline related methods and a gorm client
+type Timeline struct {
+	DB *gorm.DB
+}
+
+// Retrieve calls the TimelineSQL Find method and returns a page of the timeline of a contact via RPC
+func (t *Timeline) Retrieve(args models.TimelineArgs, reply *models.TimelineReply) error {
+	var (
+		timelineStore = models.TimelineStore(t.DB)
+		err           error
+	)
+
+	if reply.Entries, reply.Total, err = timelineStore.Find(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+// Bundle of functions managing the CRUD and the elasticsearch engine
+package controllers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/quorumsco/contacts/models"
+	"github.com/quorumsco/logs"
+	elastic "gopkg.in/olivere/elastic.v2"
+)
+
+// Trash contains the trash related methods, a gorm client and an elasticsearch client
+type Trash struct {
+	DB     *gorm.DB
+	Client *elastic.Client
+}
+
+// RetrieveCollection calls the TrashSQL Find method and returns the results via RPC
+func (t *Trash) RetrieveCollection(args models.TrashArgs, reply *models.TrashReply) error {
+	var (
+		trashStore = models.TrashStore(t.DB)
+		err        error
+	)
+
+	if reply.Trash, err = trashStore.Find(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// Restore calls the TrashSQL Restore method and indexes the restored contact back into elasticsearch
+func (t *Trash) Restore(args models.TrashArgs, reply *models.TrashReply) error {
+	var (
+		trashStore   = models.TrashStore(t.DB)
+		contactStore = models.ContactStore(t.DB)
+		err          error
+	)
+
+	if err = trashStore.Restore(args); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	if args.Type != models.TrashContact {
+		return nil
+	}
+
+	cargs := models.ContactArgs{Contact: &models.Contact{ID: args.ID, GroupID: args.GroupID}}
+	c, err := contactStore.First(cargs)
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+	if c == nil {
+		return nil
+	}
+
+	if err = (&Search{Client: t.Client}).Index(models.ContactArgs{Contact: c}, &models.ContactReply{}); err != nil {
+		return err
+	}
+	reply.ContactIDs = []uint{c.ID}
+
+	return nil
+}
+
+// Purge permanently removes the items of a group which outlived its retention period, and returns the purged contacts via RPC
+func (t *Trash) Purge(args models.TrashArgs, reply *models.TrashReply) error {
+	var (
+		settingStore = models.GroupSettingStore(t.DB)
+		trashStore   = models.TrashStore(t.DB)
+		err          error
+	)
+
+	gs, err := settingStore.First(models.GroupSettingArgs{GroupSetting: &models.GroupSetting{GroupID: args.GroupID}})
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	retention := gs.TrashRetention
+	if retention == 0 {
+		retention = models.DefaultTrashRetention
+	}
+
+	if reply.ContactIDs, err = trashStore.Purge(args, time.Now().AddDate(0, 0, -int(retention))); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return t.unIndex(reply.ContactIDs)
+}
+
+// PurgeEvery runs Purge on every group having items in the trash at each interval, it never returns
+func (t *Trash) PurgeEvery(interval time.Duration) {
+	for {
+		groups, err := models.TrashStore(t.DB).Groups()
+		if err != nil {
+			logs.Error(err)
+		}
+
+		for _, groupID := range groups {
+			var reply models.TrashReply
+			if err = t.Purge(models.TrashArgs{GroupID: groupID}, &reply); err == nil && len(reply.ContactIDs) > 0 {
+				logs.Info("purged %d contacts of group %d", len(reply.ContactIDs), groupID)
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// unIndex removes the purged contacts and their facts from elasticsearch
+func (t *Trash) unIndex(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var (
+		search = &Search{Client: t.Client}
+		terms  []interface{}
+	)
+
+	for _, id := range ids {
+		if err := search.UnIndex(models.ContactArgs{Contact: &models.Contact{ID: id}}, &models.ContactReply{}); err != nil {
+			return err
+		}
+		terms = append(terms, strconv.Itoa(int(id)))
+	}
+
+	_, err := t.Client.DeleteByQuery().Index("facts").Query(elastic.NewTermsQuery("contact_id", terms...)).Do()
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+// Bundle of functions managing the CRUD and the elasticsearch engine
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+	"github.com/quorumsco/contacts/models"
+	"github.com/quorumsco/logs"
+)
+
+// VCard contains the vCard import and export methods and a gorm client
+type VCard struct {
+	DB *gorm.DB
+}
+
+// Export returns a single contact, with its notes and tags, as a vCard via RPC
+func (t *VCard) Export(args models.VCardArgs, reply *models.VCardReply) error {
+	var (
+		contactStore = models.ContactStore(t.DB)
+		err          error
+	)
+
+	cargs := models.ContactArgs{Contact: &models.Contact{ID: args.ContactID, GroupID: args.GroupID}}
+	if reply.Contact, err = contactStore.First(cargs); err != nil {
+		logs.Error(err)
+		return err
+	}
+	if reply.Contact == nil {
+		return errors.New("export: contact not found")
+	}
+
+	if err = t.loadRelations(reply.Contact); err != nil {
+		logs.Error(err)
+		return err
+	}
+	if !reply.Contact.StripUnconsented() {
+		reply.Contact = nil
+		return errors.New("export: contact has not consented to any channel")
+	}
+	reply.Data = reply.Contact.VCard()
+
+	return nil
+}
+
+// ExportCollection returns the contacts given by IDs, by mission or the whole group as a vCard stream via RPC
+func (t *VCard) ExportCollection(args models.VCardArgs, reply *models.VCardReply) error {
+	var (
+		contactStore = models.ContactStore(t.DB)
+		cargs        = models.ContactArgs{MissionID: args.MissionID, Contact: &models.Contact{GroupID: args.GroupID}}
+		ids          = args.IDs
+		err          error
+	)
+
+	if args.MissionID != 0 && len(ids) == 0 {
+		var contacts []models.Contact
+		m := models.Mission{ID: args.MissionID, GroupID: args.GroupID}
+		if contacts, err = contactStore.FindByMission(&m, cargs); err != nil {
+			logs.Error(err)
+			return err
+		}
+		for _, c := range contacts {
+			ids = append(ids, c.ID)
+		}
+	}
+
+	if len(ids) > 0 {
+		reply.Contacts, err = contactStore.FindByIDs(ids, cargs)
+	} else if args.MissionID == 0 {
+		reply.Contacts, err = contactStore.Find(cargs)
+	}
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	// the contacts which have not consented to any channel are left out
+	var reachable []models.Contact
+	for i := range reply.Contacts {
+		if err = t.loadRelations(&reply.Contacts[i]); err != nil {
+			logs.Error(err)
+			return err
+		}
+		if reply.Contacts[i].StripUnconsented() {
+			reachable = append(reachable, reply.Contacts[i])
+		}
+	}
+	reply.Contacts = reachable
+	reply.Data = models.VCards(reply.Contacts)
+
+	return nil
+}
+
+// Import creates a contact from the first vCard of the data and returns it via RPC
+func (t *VCard) Import(args models.VCardArgs, reply *models.VCardReply) error {
+	contacts, err := models.ParseVCards(args.Data)
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+	if len(contacts) == 0 {
+		return errors.New("import: no vcard found")
+	}
+
+	if err = t.save(&contacts[0], args); err != nil {
+		logs.Error(err)
+		return err
+	}
+	reply.Contact = &contacts[0]
+
+	if reply.Job, err = t.record(args, contacts[:1]); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// ImportCollection creates a contact for every vCard of the data and returns them via RPC
+func (t *VCard) ImportCollection(args models.VCardArgs, reply *models.VCardReply) error {
+	contacts, err := models.ParseVCards(args.Data)
+	if err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	for i := range contacts {
+		if err = t.save(&contacts[i], args); err != nil {
+			logs.Error(err)
+			// the contacts saved so far can still be rolled back
+			job, rerr := t.record(args, contacts[:i])
+			if rerr != nil {
+				logs.Error(rerr)
+				return fmt.Errorf("%v, the contacts saved before could not be recorded: %v", err, rerr)
+			}
+			if job != nil {
+				return &models.ImportError{JobID: job.ID, Err: err.Error()}
+			}
+			return err
+		}
+	}
+	reply.Contacts = contacts
+
+	if reply.Job, err = t.record(args, contacts); err != nil {
+		logs.Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// loadRelations fills the notes, tags and consents of the contact, which are not preloaded by the contact store
+func (t *VCard) loadRelations(c *models.Contact) error {
+	var err error
+
+	current, err := models.ConsentStore(t.DB).Current(models.ConsentArgs{GroupID: c.GroupID, ContactID: c.ID})
+	if err != nil {
+		return err
+	}
+	c.Consents = make(map[string]bool)
+	for _, consent := range current {
+		c.Consents[consent.Channel] = consent.Granted
+	}
+
+	nargs := models.NoteArgs{ContactID: c.ID, Note: &models.Note{GroupID: c.GroupID}}
+	if c.Notes, err = models.NoteStore(t.DB).Find(nargs); err != nil {
+		return err
+	}
+
+	c.Tags, err = models.TagStore(t.DB).Find(models.TagArgs{GroupID: c.GroupID, ContactID: c.ID})
+
+	return err
+}
+
+// save inserts an imported contact and its notes into the group
+func (t *VCard) save(c *models.Contact, args models.VCardArgs) error {
+	// an UID from our own exports must not overwrite an existing contact
+	c.ID = 0
+	for i := range c.Notes {
+		c.Notes[i].GroupID = args.GroupID
+	}
+
+	return models.ContactStore(t.DB).Save(c, models.ContactArgs{UserID: args.UserID, Contact: &models.Contact{GroupID: args.GroupID}})
+}
+
+// record saves the imported contacts as a finished import job
+func (t *VCard) record(args models.VCardArgs, contacts []models.Contact) (*models.BulkJob, error) {
+	var ids []uint
+
+	if len(contacts) == 0 {
+		return nil, nil
+	}
+	for _, c := range contacts {
+		ids = append(ids, c.ID)
+	}
+
+	return models.BulkStore(t.DB).Imported(models.BulkArgs{GroupID: args.GroupID, UserID: args.UserID, IDs: ids})
+}
@@ -26,6 +26,8 @@ var (
 	TIMEOUT = 5 * time.Second
 	//RETRY number of tries
 	RETRY = 3
+	//PURGE time between each purge of the trash
+	PURGE = 24 * time.Hour
 )
 
 func init() {
@@ -80,6 +82,21 @@ func serve(ctx *cli.Context) error {
 
 	if config.Migrate() {
 		db.AutoMigrate(models.Models()...)
+		if err = models.MigrateFormdatas(db); err != nil {
+			logs.Error(err)
+		}
+		if err = models.MigrateChannels(db); err != nil {
+			logs.Error(err)
+		}
+		if err = models.MigrateTags(db); err != nil {
+			logs.Error(err)
+		}
+		if err = models.MigrateActions(db); err != nil {
+			logs.Error(err)
+		}
+		if err = models.MigrateFacts(db); err != nil {
+			logs.Error(err)
+		}
 		logs.Debug("database migrated successfully")
 	}
 
@@ -106,15 +123,32 @@ func serve(ctx *cli.Context) error {
 	checkIndex("facts", client)
 	checkIndex("actions", client)
 
-	rpc.Register(&controllers.Search{Client: client})
-	rpc.Register(&controllers.Contact{DB: db})
+	rpc.Register(&controllers.Search{Client: client, DB: db})
+	rpc.Register(&controllers.Contact{DB: db, Client: client})
 	rpc.Register(&controllers.Note{DB: db})
+	rpc.Register(&controllers.Timeline{DB: db})
 	rpc.Register(&controllers.Formdata{DB: db})
-	rpc.Register(&controllers.Tag{DB: db})
-	rpc.Register(&controllers.Mission{DB: db})
+	rpc.Register(&controllers.Form{DB: db})
+	rpc.Register(&controllers.Tag{DB: db, Client: client})
+	rpc.Register(&controllers.Mission{DB: db, Client: client})
+	rpc.Register(&controllers.Lease{DB: db})
 	rpc.Register(&controllers.Fact{DB: db})
-	rpc.Register(&controllers.Action{DB: db})
+	rpc.Register(&controllers.Action{DB: db, Client: client})
+	rpc.Register(&controllers.VCard{DB: db})
+	rpc.Register(&controllers.GroupSetting{DB: db})
+	rpc.Register(&controllers.Audit{DB: db, Client: client})
+	rpc.Register(&controllers.Consent{DB: db, Client: client})
+	rpc.Register(&controllers.GDPR{DB: db, Client: client})
+
+	bulk := &controllers.Bulk{DB: db, Client: client}
+	rpc.Register(bulk)
+	go bulk.Resume()
+
+	trash := &controllers.Trash{DB: db, Client: client}
+	rpc.Register(trash)
+	go trash.PurgeEvery(PURGE)
 	rpc.HandleHTTP()
+	http.Handle(controllers.CardDAVPrefix, &controllers.CardDAV{DB: db})
 
 	l, e := net.Listen("tcp", server.String())
 	if e != nil {
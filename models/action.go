@@ -2,23 +2,98 @@
 package models
 
 import (
+	"time"
+
 	"github.com/quorumsco/oauth2/models"
 )
 
-// Address represents all the components of a contact's address
+// States of the action campaigns
+const (
+	ActionDraft  = "draft"
+	ActionActive = "active"
+	ActionPaused = "paused"
+	ActionClosed = "closed"
+)
+
+// ActionPageSize is the number of contacts of the status matrix of an action returned at once by default
+const ActionPageSize = 50
+
+// ActionTransitions lists the states an action can move to from each state, a closed action being final
+var ActionTransitions = map[string][]string{
+	ActionDraft:  {ActionActive, ActionClosed},
+	ActionActive: {ActionPaused, ActionClosed},
+	ActionPaused: {ActionActive, ActionClosed},
+}
+
+// Action represents a campaign run by the users assigned to it on the contacts matching its search
 type Action struct {
 	ID uint `gorm:"primary_key" json:"id"`
 
-	Name string `json:"name"`
-	//ActionID uint          `json:"action_id"`
+	Name     string `json:"name"`
 	TypeData string `json:"type_data"`
-	//Data     string        `json:"data"`
-	Pitch   string             `json:"pitch"`
-	Status  string             `json:"status"` //statusdata
-	Users   []models.User      `gorm:"save_associations:false;"`
-	Facts   []Fact             `json:"facts,omitempty"`
-	UserID  []models.UserLight `json:"usersid;many2many:user_actions;"`
-	GroupID uint               `json:"group_id"`
+	Pitch    string `json:"pitch"`
+	Status   string `json:"status"` // one of the keys of ActionTransitions, changed through Transition only
+
+	// the users are stored as ActionUser, their accounts being kept by the oauth2 service
+	Users []models.UserLight `sql:"-" json:"users,omitempty"`
+
+	// the target contacts of the action are the ones matching the search
+	Search *Search `sql:"-" json:"search,omitempty"`
+	Filter string  `sql:"type:text" json:"-"` // Search as JSON
+
+	Facts       []Fact             `json:"facts,omitempty"`
+	Transitions []ActionTransition `json:"transitions,omitempty"`
+	GroupID     uint               `json:"group_id"`
+}
+
+// ActionUser represents a user assigned to an action
+type ActionUser struct {
+	ActionID uint `sql:"not null;index" json:"action_id"`
+	UserID   uint `sql:"not null;index" json:"user_id"`
+}
+
+// ActionTransition represents a change of the status of an action
+type ActionTransition struct {
+	ID       uint      `gorm:"primary_key" json:"id"`
+	ActionID uint      `sql:"not null;index" json:"action_id"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	UserID   uint      `json:"user_id,omitempty"`
+	Date     time.Time `json:"date"`
+	Comment  string    `json:"comment,omitempty"`
+}
+
+// ActionContact represents a contact targeted by an action, with the last fact recorded on it for the action
+type ActionContact struct {
+	ContactID      uint       `json:"contact_id"`
+	Firstname      string     `json:"firstname,omitempty"`
+	Surname        string     `json:"surname,omitempty"`
+	PollingStation string     `json:"pollingstation,omitempty"`
+	FactID         uint       `json:"fact_id,omitempty"`
+	Status         string     `json:"status,omitempty"`  // empty when the contact was not reached yet
+	UserID         uint       `json:"user_id,omitempty"` // the volunteer who recorded the fact
+	Date           *time.Time `json:"date,omitempty"`
+}
+
+// ActionStats sums up the last statuses of the contacts targeted by an action
+type ActionStats struct {
+	ActionID        uint               `json:"action_id"`
+	Targets         int                `json:"targets"`
+	Reached         int                `json:"reached"` // contacts having a fact for the action
+	Statuses        map[string]int     `json:"statuses"`
+	Rates           map[string]float64 `json:"rates"` // share of the targets by status
+	Volunteers      []ActionBreakdown  `json:"volunteers,omitempty"`
+	PollingStations []ActionBreakdown  `json:"pollingstations,omitempty"`
+}
+
+// ActionBreakdown represents the statuses of a part of the contacts of an action, the contacts a volunteer
+// reached or the ones of a polling station, the rates being shares of its targets
+type ActionBreakdown struct {
+	Key      string             `json:"key"` // the ID of the volunteer, or the polling station
+	Targets  int                `json:"targets"`
+	Reached  int                `json:"reached"`
+	Statuses map[string]int     `json:"statuses"`
+	Rates    map[string]float64 `json:"rates"`
 }
 
 // Contact represents all the components of a contact
@@ -28,18 +103,25 @@ type ActionsJson struct {
 	Pitch    string             `json:"pitch"`
 	Status   string             `json:"status"`
 	GroupID  uint               `json:"group_id"`
-	Users    []models.User      `json:"users"`
-	UserID   []models.UserLight `json:"usersid"`
+	Users    []models.UserLight `json:"users"`
 	Facts    []Fact             `json:"facts"`
 }
 
 // FactArgs is used in the RPC communications between the gateway and Contacts
 type ActionArgs struct {
-	Action *Action
+	UserID  uint
+	Action  *Action
+	Status  string // the status Transition moves the action to,
+	Comment string // and why
+	From    int    // first contact of the status matrix returned,
+	Size    int    // and how many, ActionPageSize by default
 }
 
 // FactReply is used in the RPC communications between the gateway and Contacts
 type ActionReply struct {
-	Action  *Action
-	Actions []Action
+	Action     *Action
+	Actions    []Action
+	ContactIDs []uint // the contacts targeted by the action
+	Contacts   []ActionContact
+	Stats      *ActionStats
 }
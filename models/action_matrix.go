@@ -0,0 +1,132 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SummarizeAction counts the last statuses of the contacts targeted by an action, overall, by volunteer and by polling station.
+// The volunteers are listed by ID and the polling stations by name, the contacts without one under an empty key.
+func SummarizeAction(actionID uint, rows []ActionContact) *ActionStats {
+	var (
+		targets = make(map[string]int)
+		reached []ActionContact
+	)
+
+	for _, row := range rows {
+		targets[row.PollingStation]++
+		if row.FactID != 0 {
+			reached = append(reached, row)
+		}
+	}
+
+	return SummarizeActionCounts(actionID, targets, reached)
+}
+
+// SummarizeActionCounts does as SummarizeAction from the number of contacts targeted by polling station,
+// the contacts reached included, and the last fact of each contact reached
+func SummarizeActionCounts(actionID uint, targets map[string]int, reached []ActionContact) *ActionStats {
+	var (
+		stats      = &ActionStats{ActionID: actionID, Statuses: make(map[string]int)}
+		volunteers = make(map[uint]*ActionBreakdown)
+		stations   = make(map[string]*ActionBreakdown)
+		users      []uint
+		names      []string
+	)
+
+	station := func(name string) *ActionBreakdown {
+		st := stations[name]
+		if st == nil {
+			st = &ActionBreakdown{Key: name, Statuses: make(map[string]int)}
+			stations[name] = st
+			names = append(names, name)
+		}
+		return st
+	}
+	for name, n := range targets {
+		station(name).Targets += n
+		stats.Targets += n
+	}
+
+	for _, row := range reached {
+		st := station(row.PollingStation)
+		stats.Reached++
+		stats.Statuses[row.Status]++
+		st.Reached++
+		st.Statuses[row.Status]++
+
+		// a volunteer targets the contacts they reached
+		v := volunteers[row.UserID]
+		if v == nil {
+			v = &ActionBreakdown{Key: strconv.Itoa(int(row.UserID)), Statuses: make(map[string]int)}
+			volunteers[row.UserID] = v
+			users = append(users, row.UserID)
+		}
+		v.Targets++
+		v.Reached++
+		v.Statuses[row.Status]++
+	}
+
+	stats.Rates = rates(stats.Statuses, stats.Targets)
+
+	sort.Slice(users, func(i, j int) bool { return users[i] < users[j] })
+	for _, id := range users {
+		v := volunteers[id]
+		v.Rates = rates(v.Statuses, v.Targets)
+		stats.Volunteers = append(stats.Volunteers, *v)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		st := stations[name]
+		st.Rates = rates(st.Statuses, st.Targets)
+		stats.PollingStations = append(stats.PollingStations, *st)
+	}
+
+	return stats
+}
+
+// PageActionContacts orders the contacts of the status matrix of an action by name and returns size of them from the
+// index from, ActionPageSize when size is not given
+func PageActionContacts(rows []ActionContact, from int, size int) []ActionContact {
+	if size <= 0 {
+		size = ActionPageSize
+	}
+	if from < 0 {
+		from = 0
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if sa, sb := strings.ToLower(a.Surname), strings.ToLower(b.Surname); sa != sb {
+			return sa < sb
+		}
+		if fa, fb := strings.ToLower(a.Firstname), strings.ToLower(b.Firstname); fa != fb {
+			return fa < fb
+		}
+		return a.ContactID < b.ContactID
+	})
+
+	if from >= len(rows) {
+		return nil
+	}
+	if from+size > len(rows) {
+		return rows[from:]
+	}
+	return rows[from : from+size]
+}
+
+// rates returns the share of the total of each status
+func rates(statuses map[string]int, total int) map[string]float64 {
+	r := make(map[string]float64)
+	if total == 0 {
+		return r
+	}
+
+	for status, n := range statuses {
+		r[status] = float64(n) / float64(total)
+	}
+
+	return r
+}
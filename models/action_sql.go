@@ -2,9 +2,13 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/quorumsco/oauth2/models"
 )
 
 // ActionSQL contains a Gorm client and the action and gorm related methods
@@ -12,20 +16,125 @@ type ActionSQL struct {
 	DB *gorm.DB
 }
 
-// Save inserts a new action into the database
+// Save inserts a new action as a draft into the database, or updates an action of the group.
+// The status is left as it is, it only moves through Transition.
 func (s *ActionSQL) Save(f *Action, args ActionArgs) error {
 	if f == nil {
 		return errors.New("save: action is nil")
 	}
 
 	f.GroupID = args.Action.GroupID
+	// the history is only written by Transition and the facts by the volunteers
+	f.Transitions = nil
+	f.Facts = nil
+	if err := f.encode(); err != nil {
+		return err
+	}
+
 	if f.ID == 0 {
-		err := s.DB.Create(f).Error
-		s.DB.Last(f)
+		f.Status = ActionDraft
+		tx := s.DB.Begin()
+		if err := tx.Create(f).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := saveActionUsers(tx, f); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := recordAudit(tx, f.auditEntry(AuditCreate, args), nil, f); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit().Error
+	}
+
+	var before Action
+	if err := s.DB.Where("group_id = ? AND id = ?", args.Action.GroupID, f.ID).First(&before).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.Action.GroupID, f.ID).First(&before).RecordNotFound() {
+			return errors.New("save: action not found")
+		}
 		return err
 	}
+	f.Status = before.Status
+
+	tx := s.DB.Begin()
+	if err := tx.Where("group_id = ?", args.Action.GroupID).Save(f).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := saveActionUsers(tx, f); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := recordAudit(tx, f.auditEntry(AuditUpdate, args), &before, f); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// saveActionUsers replaces the users assigned to an action by the ones it carries
+func saveActionUsers(db *gorm.DB, f *Action) error {
+	if err := db.Where("action_id = ?", f.ID).Delete(&ActionUser{}).Error; err != nil {
+		return err
+	}
+
+	seen := make(map[int64]bool)
+	for _, u := range f.Users {
+		if u.ID <= 0 || seen[u.ID] {
+			continue
+		}
+		seen[u.ID] = true
+		if err := db.Create(&ActionUser{ActionID: f.ID, UserID: uint(u.ID)}).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Transition moves an action of the group to args.Status, provided ActionTransitions allows it, and keeps it in its history
+func (s *ActionSQL) Transition(args ActionArgs) (*Action, error) {
+	f, err := s.First(args)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, errors.New("transition: action not found")
+	}
 
-	return s.DB.Where("group_id = ?", args.Action.GroupID).Save(f).Error
+	if !contains(ActionTransitions[f.Status], args.Status) {
+		return nil, fmt.Errorf("transition: an action can't go from %q to %q", f.Status, args.Status)
+	}
+
+	before := *f
+	t := ActionTransition{ActionID: f.ID, From: f.Status, To: args.Status, UserID: args.UserID, Date: time.Now(), Comment: args.Comment}
+
+	tx := s.DB.Begin()
+	res := tx.Model(&Action{}).Where("group_id = ? AND id = ? AND status = ?", f.GroupID, f.ID, f.Status).UpdateColumn("status", args.Status)
+	if res.Error != nil {
+		tx.Rollback()
+		return nil, res.Error
+	}
+	// another transition went through since the action was read
+	if res.RowsAffected != 1 {
+		tx.Rollback()
+		return nil, fmt.Errorf("transition: conflict, action %d is no longer %q", f.ID, f.Status)
+	}
+	if err = tx.Create(&t).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	f.Status = args.Status
+	f.Transitions = append(f.Transitions, t)
+	if err = recordAudit(tx, f.auditEntry(AuditUpdate, args), &before, f); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return f, tx.Commit().Error
 }
 
 // Delete removes a action from the database
@@ -34,49 +143,230 @@ func (s *ActionSQL) Delete(f *Action, args ActionArgs) error {
 		return errors.New("delete: action is nil")
 	}
 
-	return s.DB.Where("group_id = ?", args.Action.GroupID).Delete(f).Error
+	var before Action
+	if err := s.DB.Where("group_id = ? AND id = ?", args.Action.GroupID, f.ID).First(&before).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.Action.GroupID, f.ID).First(&before).RecordNotFound() {
+			return nil
+		}
+		return err
+	}
+
+	tx := s.DB.Begin()
+	if err := tx.Where("group_id = ?", args.Action.GroupID).Delete(&before).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("action_id = ?", before.ID).Delete(&ActionUser{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := recordAudit(tx, before.auditEntry(AuditDelete, args), &before, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// auditEntry returns the audit log entry of a change made to the action
+func (f *Action) auditEntry(action string, args ActionArgs) AuditEntry {
+	return AuditEntry{GroupID: f.GroupID, Entity: "action", EntityID: f.ID, Action: action, UserID: args.UserID}
+}
+
+// encode stores the search of the action as JSON
+func (f *Action) encode() error {
+	f.Filter = ""
+	if f.Search == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(f.Search)
+	if err != nil {
+		return err
+	}
+	f.Filter = string(data)
+
+	return nil
 }
 
-// First returns a action from the database using his ID
+// load fills the users and the search of the action
+func (f *Action) load(db *gorm.DB) error {
+	var ids []uint
+
+	if err := db.Model(&ActionUser{}).Where("action_id = ?", f.ID).Order("user_id").Pluck("user_id", &ids).Error; err != nil {
+		return err
+	}
+	f.Users = nil
+	for _, id := range ids {
+		f.Users = append(f.Users, models.UserLight{ID: int64(id)})
+	}
+
+	f.Search = nil
+	if f.Filter != "" {
+		f.Search = &Search{}
+		return json.Unmarshal([]byte(f.Filter), f.Search)
+	}
+
+	return nil
+}
+
+// First returns an action of the group from the database using its ID, with its facts and its history
 func (s *ActionSQL) First(args ActionArgs) (*Action, error) {
 	var f Action
 
-	if err := s.DB.Where(args.Action).Preload("Facts").First(&f).Error; err != nil {
-		if s.DB.Where(args.Action).First(&f).RecordNotFound() {
+	if args.Action == nil {
+		return nil, errors.New("first: no action given")
+	}
+
+	db := s.DB.Where("group_id = ? AND id = ?", args.Action.GroupID, args.Action.ID)
+	if err := db.Preload("Facts").Preload("Transitions").First(&f).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.Action.GroupID, args.Action.ID).First(&f).RecordNotFound() {
 			return nil, nil
 		}
 		return nil, err
 	}
-	// if err := s.DB.Where(f.ActionID).First(&f.Action).Error; err != nil {
-	// 	if s.DB.Where(f.ActionID).First(&f.Action).RecordNotFound() {
-	// 		return nil, err
-	// 	}
-	// 	return nil, err
-	// }
-	// err := s.DB.Where(f.ContactID).First(&f.Contact).Error
-	// if err != nil && !s.DB.Where(f.ContactID).First(&f.Contact).RecordNotFound() {
-	// 	return nil, err
-	// }
-
-	// if err == nil {
-	// 	if err := s.DB.Where(f.Contact.AddressID).First(&f.Contact.Address).Error; err != nil && !s.DB.Where(f.Contact.AddressID).First(&f.Contact.Address).RecordNotFound() {
-	// 		return nil, err
-	// 	}
-	// }
-
-	return &f, nil
+
+	return &f, f.load(s.DB)
 }
 
-// Find returns all the actions with a given groupID from the database
+// Brief returns an action of the group from the database using its ID, without its facts and its history
+func (s *ActionSQL) Brief(args ActionArgs) (*Action, error) {
+	var f Action
+
+	if args.Action == nil {
+		return nil, errors.New("first: no action given")
+	}
+
+	db := s.DB.Where("group_id = ? AND id = ?", args.Action.GroupID, args.Action.ID)
+	if err := db.First(&f).Error; err != nil {
+		if db.First(&f).RecordNotFound() {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &f, f.load(s.DB)
+}
+
+// Find returns all the actions with a given groupID from the database, restricted to a status if args.Action.Status is set
 func (s *ActionSQL) Find(args ActionArgs) ([]Action, error) {
 	var actions []Action
 
-	//err := s.DB.Where("group_id = ?", args.Action.GroupID).Find(&actions).Error
-	err := s.DB.Where("group_id = ?", args.Action.GroupID).Preload("Facts").Preload("Contacts").Find(&actions).Error
-
-	if err != nil {
+	db := s.DB.Where("group_id = ?", args.Action.GroupID)
+	if args.Action.Status != "" {
+		db = db.Where("status = ?", args.Action.Status)
+	}
+	if err := db.Preload("Facts").Preload("Transitions").Find(&actions).Error; err != nil {
 		return nil, err
 	}
+	for i := range actions {
+		if err := actions[i].load(s.DB); err != nil {
+			return nil, err
+		}
+	}
 
 	return actions, nil
 }
+
+// Matrix returns the contacts targeted by an action of the group, with the last fact recorded on each for the action.
+// The targets are the given contacts and the ones having a fact for the action, the deleted contacts being left out.
+func (s *ActionSQL) Matrix(args ActionArgs, targets []uint) ([]ActionContact, error) {
+	var (
+		f        Action
+		facts    []Fact
+		contacts []Contact
+		rows     []ActionContact
+	)
+
+	if args.Action == nil {
+		return nil, errors.New("matrix: no action given")
+	}
+	if err := s.DB.Where("group_id = ? AND id = ?", args.Action.GroupID, args.Action.ID).First(&f).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.Action.GroupID, args.Action.ID).First(&f).RecordNotFound() {
+			return nil, errors.New("matrix: action not found")
+		}
+		return nil, err
+	}
+
+	if err := s.DB.Where("group_id = ? AND action_id = ?", f.GroupID, f.ID).Order("id").Find(&facts).Error; err != nil {
+		return nil, err
+	}
+
+	var (
+		ids    = append([]uint{}, targets...)
+		target = make(map[uint]bool)
+		last   = make(map[uint]Fact)
+	)
+	for _, id := range targets {
+		target[id] = true
+	}
+	for _, fact := range facts {
+		if !target[fact.ContactID] {
+			target[fact.ContactID] = true
+			ids = append(ids, fact.ContactID)
+		}
+		last[fact.ContactID] = fact
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	if err := s.DB.Where("group_id = ? AND id IN (?)", f.GroupID, ids).Preload("Address").Find(&contacts).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range contacts {
+		row := ActionContact{ContactID: c.ID, Firstname: c.Firstname, Surname: c.Surname, PollingStation: c.Address.PollingStation}
+		if fact, ok := last[c.ID]; ok {
+			row.FactID, row.Status, row.UserID, row.Date = fact.ID, fact.Status, fact.UserID, fact.OccurredAt
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, s.Attribute(args, rows)
+}
+
+// Attribute sets on the contacts of the status matrix of an action of the group the volunteer who recorded
+// their last fact and when, from the audit log, for the facts recorded before their author was
+func (s *ActionSQL) Attribute(args ActionArgs, rows []ActionContact) error {
+	var (
+		ids     []uint
+		entries []AuditEntry
+	)
+
+	for _, row := range rows {
+		if row.FactID != 0 && row.UserID == 0 {
+			ids = append(ids, row.FactID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if err := s.DB.Where("group_id = ? AND entity = ? AND action = ? AND entity_id IN (?)", args.Action.GroupID, "fact", AuditCreate, ids).Find(&entries).Error; err != nil {
+		return err
+	}
+	created := make(map[uint]AuditEntry)
+	for _, e := range entries {
+		created[e.EntityID] = e
+	}
+
+	for i, row := range rows {
+		if e, ok := created[row.FactID]; ok && row.UserID == 0 {
+			date := e.Date
+			rows[i].UserID, rows[i].Date = e.UserID, &date
+		}
+	}
+
+	return nil
+}
+
+// MigrateActions moves the actions created before the campaign lifecycle to the draft status
+func MigrateActions(db *gorm.DB) error {
+	var statuses []string
+	for status := range ActionTransitions {
+		statuses = append(statuses, status)
+	}
+	statuses = append(statuses, ActionClosed)
+
+	return db.Model(&Action{}).Where("status IS NULL OR status NOT IN (?)", statuses).UpdateColumn("status", ActionDraft).Error
+}
@@ -8,7 +8,11 @@ type ActionDS interface {
 	Save(*Action, ActionArgs) error
 	Delete(*Action, ActionArgs) error
 	First(ActionArgs) (*Action, error)
+	Brief(ActionArgs) (*Action, error)
 	Find(ActionArgs) ([]Action, error)
+	Transition(ActionArgs) (*Action, error)
+	Matrix(ActionArgs, []uint) ([]ActionContact, error)
+	Attribute(ActionArgs, []ActionContact) error
 }
 
 // Actionstore returns a ActionDS implementing CRUD methods for the facts and containing a gorm client
@@ -0,0 +1,47 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "time"
+
+// Actions recorded in the audit log
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+)
+
+// AuditEntry represents a change made to an entity through the stores, it is never updated nor deleted
+type AuditEntry struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	GroupID   uint      `sql:"not null;index" json:"group_id"`
+	Entity    string    `sql:"not null" json:"entity"`
+	EntityID  uint      `sql:"not null" json:"entity_id"`
+	ContactID uint      `sql:"index" json:"contact_id,omitempty"`
+	Action    string    `sql:"not null" json:"action"`
+	UserID    uint      `json:"user_id,omitempty"`
+	Date      time.Time `json:"date"`
+	Changes   string    `sql:"type:text" json:"changes"`  // as {"field": {"before": x, "after": y}}
+	Snapshot  string    `sql:"type:text" json:"snapshot"` // the entity after the change, or before its deletion
+}
+
+// AuditChange represents the values of a field before and after a change
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditArgs is used in the RPC communications between the gateway and Contacts
+type AuditArgs struct {
+	GroupID   uint
+	ContactID uint
+	EntryID   uint
+	UserID    uint
+}
+
+// AuditReply is used in the RPC communications between the gateway and Contacts
+type AuditReply struct {
+	AuditEntry   *AuditEntry
+	AuditEntries []AuditEntry
+	Contact      *Contact
+}
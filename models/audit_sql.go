@@ -0,0 +1,141 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AuditSQL contains a Gorm client and the audit log and gorm related methods
+type AuditSQL struct {
+	DB *gorm.DB
+}
+
+// First returns an entry of the audit log from the database using its ID
+func (s *AuditSQL) First(args AuditArgs) (*AuditEntry, error) {
+	var e AuditEntry
+
+	if err := s.DB.Where("group_id = ? AND id = ?", args.GroupID, args.EntryID).First(&e).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.GroupID, args.EntryID).First(&e).RecordNotFound() {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// Find returns the history of a contact, its notes, formdatas, tags and facts included, newest first
+func (s *AuditSQL) Find(args AuditArgs) ([]AuditEntry, error) {
+	var entries []AuditEntry
+
+	err := s.DB.Where("group_id = ? AND contact_id = ?", args.GroupID, args.ContactID).Order("date desc, id desc").Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// recordAudit appends an entry to the audit log, before is nil for a creation and after is nil for a deletion
+func recordAudit(db *gorm.DB, e AuditEntry, before interface{}, after interface{}) error {
+	var (
+		old, cur map[string]interface{}
+		err      error
+	)
+
+	if old, err = auditFields(before); err != nil {
+		return err
+	}
+	if cur, err = auditFields(after); err != nil {
+		return err
+	}
+
+	changes := make(map[string]AuditChange)
+	flatOld, flatCur := flattenAudit("", old), flattenAudit("", cur)
+	// the version moves on every update, it is no change of its own
+	delete(flatOld, "version")
+	delete(flatCur, "version")
+	for k, v := range flatCur {
+		if !reflect.DeepEqual(flatOld[k], v) {
+			changes[k] = AuditChange{Before: flatOld[k], After: v}
+		}
+	}
+	for k, v := range flatOld {
+		if _, ok := flatCur[k]; !ok {
+			changes[k] = AuditChange{Before: v}
+		}
+	}
+	if e.Action == AuditUpdate && len(changes) == 0 {
+		return nil
+	}
+
+	snapshot := cur
+	if after == nil {
+		snapshot = old
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	e.Changes = string(data)
+	if data, err = json.Marshal(snapshot); err != nil {
+		return err
+	}
+	e.Snapshot = string(data)
+	e.Date = time.Now()
+
+	return db.Create(&e).Error
+}
+
+// auditFields returns the JSON fields of an entity, leaving out the collections and the embedded
+// entities other than the address, which have their own entries
+func auditFields(v interface{}) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+
+	if v == nil || reflect.ValueOf(v).IsNil() {
+		return fields, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	for k, value := range fields {
+		switch value.(type) {
+		case []interface{}:
+			delete(fields, k)
+		case map[string]interface{}:
+			if k != "address" {
+				delete(fields, k)
+			}
+		}
+	}
+
+	return fields, nil
+}
+
+// flattenAudit flattens the nested fields, {"address": {"city": x}} becoming {"address.city": x}
+func flattenAudit(prefix string, fields map[string]interface{}) map[string]interface{} {
+	flat := make(map[string]interface{})
+
+	for k, v := range fields {
+		if nested, ok := v.(map[string]interface{}); ok {
+			for nk, nv := range flattenAudit(prefix+k+".", nested) {
+				flat[nk] = nv
+			}
+			continue
+		}
+		flat[prefix+k] = v
+	}
+
+	return flat
+}
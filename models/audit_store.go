@@ -0,0 +1,15 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "github.com/jinzhu/gorm"
+
+// AuditDS implements the AuditSQL methods
+type AuditDS interface {
+	First(AuditArgs) (*AuditEntry, error)
+	Find(AuditArgs) ([]AuditEntry, error)
+}
+
+// AuditStore returns an AuditDS reading the audit log and containing a gorm client
+func AuditStore(db *gorm.DB) AuditDS {
+	return &AuditSQL{DB: db}
+}
@@ -0,0 +1,96 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "time"
+
+// Actions of the bulk jobs, given in BulkJob.Action
+const (
+	BulkAddTag      = "add_tag"
+	BulkRemoveTag   = "remove_tag"
+	BulkAddMission  = "add_mission"
+	BulkSetOwner    = "set_owner"
+	BulkSetFormdata = "set_formdata"
+	BulkDelete      = "delete"
+	BulkImport      = "import" // recorded by the vCard imports, it can only be rolled back
+)
+
+// BulkActions lists the actions of the bulk jobs
+var BulkActions = []string{BulkAddTag, BulkRemoveTag, BulkAddMission, BulkSetOwner, BulkSetFormdata, BulkDelete}
+
+// States of the bulk jobs and of their items
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+
+	JobReverted = "reverted" // the item or the job was rolled back
+	JobConflict = "conflict" // the item could not be rolled back because of a later edit
+)
+
+// BulkBatchSize is the number of contacts a bulk job handles at once, its progress being saved after each batch
+const BulkBatchSize = 100
+
+// BulkJob represents an action applied in the background to a selection of contacts
+type BulkJob struct {
+	ID      uint   `gorm:"primary_key" json:"id"`
+	GroupID uint   `sql:"not null;index" json:"group_id"`
+	UserID  uint   `json:"user_id,omitempty"`
+	Action  string `sql:"not null" json:"action"` // one of BulkActions
+
+	// parameters of the action
+	TagID     uint   `json:"tag_id,omitempty"`
+	MissionID uint   `json:"mission_id,omitempty"`
+	OwnerID   uint   `json:"owner_id,omitempty"`
+	FormID    uint   `json:"form_id,omitempty"`
+	FormRefID uint   `json:"form_ref_id,omitempty"`
+	Data      string `json:"data,omitempty"`
+
+	Status   string     `sql:"not null" json:"status"`
+	Total    int        `json:"total"`
+	Done     int        `json:"done"`
+	Failed   int        `json:"failed"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	Reverted  int        `json:"reverted,omitempty"`
+	Conflicts int        `json:"conflicts,omitempty"`
+	Undone    *time.Time `json:"undone,omitempty"`
+
+	Items []BulkItem `json:"items,omitempty"` // the failed and conflicting items when retrieved
+}
+
+// BulkItem represents a contact of a bulk job, with the reason the action or its rollback failed on it
+type BulkItem struct {
+	ID        uint   `gorm:"primary_key" json:"id"`
+	BulkJobID uint   `sql:"not null;index" json:"job_id"`
+	ContactID uint   `json:"contact_id"`
+	Status    string `sql:"not null" json:"status"`
+	Error     string `json:"error,omitempty"`
+	Change    string `sql:"type:text" json:"change,omitempty"` // BulkChange as JSON
+}
+
+// BulkChange is the change set recorded on an item once the action applied, used to roll the job back
+type BulkChange struct {
+	Changed    bool   `json:"changed"`               // false when the contact was already as the action sets it
+	Version    uint   `json:"version,omitempty"`     // version of the contact after the action, a later edit moves it on
+	OwnerID    uint   `json:"owner_id,omitempty"`    // prior owner
+	CreatedIDs []uint `json:"created_ids,omitempty"` // formdatas created
+	DeletedIDs []uint `json:"deleted_ids,omitempty"` // formdatas deleted
+}
+
+// BulkArgs is used in the RPC communications between the gateway and Contacts
+type BulkArgs struct {
+	GroupID uint
+	UserID  uint
+	Job     *BulkJob
+	Search  *Search // the selection as given to SearchContacts,
+	IDs     []uint  // or the contacts given by IDs
+	DryRun  bool    // Rollback only reports the items it could not roll back
+}
+
+// BulkReply is used in the RPC communications between the gateway and Contacts
+type BulkReply struct {
+	Job  *BulkJob
+	Jobs []BulkJob
+}
@@ -0,0 +1,496 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// BulkSQL contains a Gorm client and the bulk job and gorm related methods
+type BulkSQL struct {
+	DB *gorm.DB
+}
+
+// Create checks the action of a job and saves it as pending, with an item for each contact given by args.IDs
+func (s *BulkSQL) Create(j *BulkJob, args BulkArgs) error {
+	if j == nil {
+		return errors.New("create: job is nil")
+	}
+
+	j.ID, j.GroupID, j.UserID = 0, args.GroupID, args.UserID
+	if err := j.check(s.DB); err != nil {
+		return err
+	}
+	j.Status, j.Total, j.Done, j.Failed = JobPending, len(args.IDs), 0, 0
+	j.Created, j.Finished = time.Now(), nil
+	j.Items = nil
+
+	tx := s.DB.Begin()
+	if err := tx.Create(j).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, id := range args.IDs {
+		if err := tx.Create(&BulkItem{BulkJobID: j.ID, ContactID: id, Status: JobPending}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+// check refuses the jobs whose action can't be applied
+func (j *BulkJob) check(db *gorm.DB) error {
+	switch j.Action {
+	case BulkAddTag, BulkRemoveTag:
+		var t Tag
+		if db.Where("group_id = ? AND id = ?", j.GroupID, j.TagID).First(&t).RecordNotFound() {
+			return errors.New("create: tag not found")
+		}
+	case BulkAddMission:
+		var m Mission
+		if db.Where("group_id = ? AND id = ?", j.GroupID, j.MissionID).First(&m).RecordNotFound() {
+			return errors.New("create: mission not found")
+		}
+	case BulkSetOwner:
+		if j.OwnerID == 0 {
+			return errors.New("create: no owner given")
+		}
+	case BulkSetFormdata:
+		if j.FormID == 0 {
+			return errors.New("create: no question given")
+		}
+	case BulkDelete:
+	default:
+		return fmt.Errorf("create: unknown action %q", j.Action)
+	}
+
+	return nil
+}
+
+// First returns a job of a group from the database using its ID, with its failed and conflicting items
+func (s *BulkSQL) First(args BulkArgs) (*BulkJob, error) {
+	var j BulkJob
+
+	if args.Job == nil {
+		return nil, errors.New("first: no job given")
+	}
+
+	if err := s.DB.Where("group_id = ? AND id = ?", args.GroupID, args.Job.ID).Preload("Items", "status IN (?)", []string{JobFailed, JobConflict}).First(&j).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.GroupID, args.Job.ID).First(&j).RecordNotFound() {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &j, nil
+}
+
+// Find returns the jobs of a group, newest first
+func (s *BulkSQL) Find(args BulkArgs) ([]BulkJob, error) {
+	var jobs []BulkJob
+
+	if err := s.DB.Where("group_id = ?", args.GroupID).Order("id desc").Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// Unfinished returns the jobs of all the groups which are pending or were interrupted while running
+func (s *BulkSQL) Unfinished() ([]BulkJob, error) {
+	var jobs []BulkJob
+
+	if err := s.DB.Where("status IN (?)", []string{JobPending, JobRunning}).Order("id").Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// RunBatch applies the action of a job to its next BulkBatchSize pending items and saves its progress.
+// It returns the contacts the action was applied to, none once the job is finished.
+func (s *BulkSQL) RunBatch(j *BulkJob) ([]uint, error) {
+	var (
+		items   []BulkItem
+		applied []uint
+	)
+
+	if err := s.DB.Where("bulk_job_id = ? AND status = ?", j.ID, JobPending).Order("id").Limit(BulkBatchSize).Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	if len(items) == 0 {
+		now := time.Now()
+		j.Status, j.Finished = JobDone, &now
+		if j.Done == 0 && j.Failed > 0 {
+			j.Status = JobFailed
+		}
+		return nil, s.DB.Model(j).UpdateColumns(map[string]interface{}{"status": j.Status, "finished": j.Finished}).Error
+	}
+
+	j.Status = JobRunning
+	for i := range items {
+		if change, err := j.apply(s.DB, items[i].ContactID); err != nil {
+			items[i].Status, items[i].Error = JobFailed, err.Error()
+			j.Failed++
+		} else {
+			data, err := json.Marshal(change)
+			if err != nil {
+				return nil, err
+			}
+			items[i].Status, items[i].Change = JobDone, string(data)
+			j.Done++
+			applied = append(applied, items[i].ContactID)
+		}
+		item := map[string]interface{}{"status": items[i].Status, "error": items[i].Error, "change": items[i].Change}
+		if err := s.DB.Model(&items[i]).UpdateColumns(item).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	progress := map[string]interface{}{"status": j.Status, "done": j.Done, "failed": j.Failed}
+	if err := s.DB.Model(j).UpdateColumns(progress).Error; err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// apply applies the action of a job to a contact through the stores and returns what it changed
+func (j *BulkJob) apply(db *gorm.DB, contactID uint) (*BulkChange, error) {
+	var (
+		contactStore = ContactStore(db)
+		cargs        = ContactArgs{UserID: j.UserID, Contact: &Contact{GroupID: j.GroupID}}
+	)
+
+	c, err := contactStore.First(ContactArgs{Contact: &Contact{ID: contactID, GroupID: j.GroupID}})
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, errors.New("contact not found")
+	}
+
+	change := &BulkChange{Changed: true, Version: c.Version}
+	switch j.Action {
+	case BulkAddTag, BulkRemoveTag:
+		var t Tag
+		if err = db.Where("group_id = ? AND id = ?", j.GroupID, j.TagID).First(&t).Error; err != nil {
+			return nil, err
+		}
+		targs := TagArgs{GroupID: j.GroupID, ContactID: c.ID, UserID: j.UserID}
+		if j.Action == BulkAddTag {
+			change.Changed = !containsID(c.TagIDs, t.ID)
+			return change, TagStore(db).Save(&t, targs)
+		}
+		change.Changed = containsID(c.TagIDs, t.ID)
+		return change, TagStore(db).Delete(&t, targs)
+	case BulkAddMission:
+		change.Changed = !containsID(c.MissionIDs, j.MissionID)
+		return change, MissionStore(db).AddContact(MissionArgs{UserID: j.UserID, Mission: &Mission{ID: j.MissionID, GroupID: j.GroupID}}, c.ID)
+	case BulkSetOwner:
+		change.OwnerID, change.Changed = c.UserID, c.UserID != j.OwnerID
+		c.UserID = j.OwnerID
+		c.Formdatas = nil
+		if err = contactStore.Save(c, cargs); err != nil {
+			return nil, err
+		}
+		change.Version = c.Version
+		return change, nil
+	case BulkSetFormdata:
+		// the answer replaces the previous answers to the question
+		var ops []Formdata
+		for _, f := range c.Formdatas {
+			if f.FormID == j.FormID {
+				ops = append(ops, Formdata{ID: f.ID, Op: FormdataRemove})
+				change.DeletedIDs = append(change.DeletedIDs, f.ID)
+			}
+		}
+		ops = append(ops, Formdata{FormID: j.FormID, Form_ref_id: j.FormRefID, Data: j.Data, Op: FormdataAdd})
+		c.Formdatas = ops
+		if err = contactStore.Save(c, cargs); err != nil {
+			return nil, err
+		}
+		for _, f := range c.Formdatas {
+			if f.FormID == j.FormID && !containsID(change.DeletedIDs, f.ID) {
+				change.CreatedIDs = append(change.CreatedIDs, f.ID)
+			}
+		}
+		change.Version = c.Version
+		return change, nil
+	case BulkDelete:
+		return change, contactStore.Delete(c, cargs)
+	}
+
+	return nil, fmt.Errorf("unknown action %q", j.Action)
+}
+
+// Imported records the contacts created by an import as a finished job, so that the import can be rolled back
+func (s *BulkSQL) Imported(args BulkArgs) (*BulkJob, error) {
+	now := time.Now()
+	j := &BulkJob{GroupID: args.GroupID, UserID: args.UserID, Action: BulkImport, Status: JobDone, Total: len(args.IDs), Done: len(args.IDs), Created: now, Finished: &now}
+
+	tx := s.DB.Begin()
+	if err := tx.Create(j).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	for _, id := range args.IDs {
+		data, err := json.Marshal(BulkChange{Changed: true, Version: 1, CreatedIDs: []uint{id}})
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if err = tx.Create(&BulkItem{BulkJobID: j.ID, ContactID: id, Status: JobDone, Change: string(data)}).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return j, tx.Commit().Error
+}
+
+// Rollback reverts the items of a finished job, newest first, and returns the contacts it changed.
+// The items edited since the job are left as they are and reported in j.Items as conflicts, they are
+// tried again by the next rollback. With dryRun, nothing is reverted and only the conflicts are reported.
+func (s *BulkSQL) Rollback(j *BulkJob, dryRun bool) ([]uint, error) {
+	var (
+		reverted  []uint
+		conflicts []BulkItem
+		lastID    uint
+	)
+
+	if j.Status == JobPending || j.Status == JobRunning {
+		return nil, errors.New("rollback: job is still running")
+	}
+
+	for {
+		var items []BulkItem
+
+		db := s.DB.Where("bulk_job_id = ? AND status IN (?)", j.ID, []string{JobDone, JobConflict})
+		if lastID != 0 {
+			db = db.Where("id < ?", lastID)
+		}
+		if err := db.Order("id desc").Limit(BulkBatchSize).Find(&items).Error; err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			break
+		}
+		lastID = items[len(items)-1].ID
+
+		for i := range items {
+			item := &items[i]
+			reason, err := j.revert(s.DB, item, dryRun)
+			if err != nil {
+				return nil, err
+			}
+
+			if reason != "" {
+				item.Status, item.Error = JobConflict, reason
+				conflicts = append(conflicts, *item)
+			} else {
+				item.Status, item.Error = JobReverted, ""
+				reverted = append(reverted, item.ContactID)
+			}
+			if dryRun {
+				continue
+			}
+			if err = s.DB.Model(item).UpdateColumns(map[string]interface{}{"status": item.Status, "error": item.Error}).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	j.Items = conflicts
+	if dryRun {
+		j.Conflicts = len(conflicts)
+		return nil, nil
+	}
+
+	now := time.Now()
+	j.Status, j.Undone, j.Conflicts = JobReverted, &now, len(conflicts)
+	j.Reverted += len(reverted)
+	rollback := map[string]interface{}{"status": j.Status, "undone": j.Undone, "reverted": j.Reverted, "conflicts": j.Conflicts}
+	if err := s.DB.Model(j).UpdateColumns(rollback).Error; err != nil {
+		return nil, err
+	}
+
+	return reverted, nil
+}
+
+// revert rolls back the change recorded on an item through the stores. It returns why the item can't be
+// rolled back when the contact was edited since the job, the error being kept for the database failures.
+func (j *BulkJob) revert(db *gorm.DB, item *BulkItem, dryRun bool) (string, error) {
+	var (
+		change       BulkChange
+		contactStore = ContactStore(db)
+		cargs        = ContactArgs{UserID: j.UserID, Contact: &Contact{GroupID: j.GroupID}}
+		targs        = TrashArgs{GroupID: j.GroupID, UserID: j.UserID}
+	)
+
+	// the item no longer points to a contact erased on request, see GDPRSQL.Erase
+	if item.ContactID == 0 {
+		return "contact was erased since", nil
+	}
+	if err := json.Unmarshal([]byte(item.Change), &change); err != nil {
+		return "", err
+	}
+	if !change.Changed {
+		return "", nil
+	}
+
+	c, err := contactStore.First(ContactArgs{Contact: &Contact{ID: item.ContactID, GroupID: j.GroupID}})
+	if err != nil {
+		return "", err
+	}
+
+	if j.Action == BulkDelete {
+		if c != nil {
+			return "contact was restored since", nil
+		}
+		var trashed Contact
+		if db.Unscoped().Where("group_id = ? AND id = ? AND deleted_at IS NOT NULL", j.GroupID, item.ContactID).First(&trashed).RecordNotFound() {
+			return "contact was purged since", nil
+		}
+		if dryRun {
+			return "", nil
+		}
+		targs.Type, targs.ID = TrashContact, item.ContactID
+		return "", TrashStore(db).Restore(targs)
+	}
+
+	if c == nil {
+		if j.Action == BulkImport {
+			// already deleted, there is nothing left to roll back
+			return "", nil
+		}
+		return "contact was deleted since", nil
+	}
+	if reason := j.conflict(db, c, change); reason != "" {
+		return reason, nil
+	}
+	if dryRun {
+		return "", nil
+	}
+
+	// a tag or a mission already taken back by a later edit is left as it is
+	switch j.Action {
+	case BulkAddTag, BulkRemoveTag:
+		var t Tag
+		if db.Where("group_id = ? AND id = ?", j.GroupID, j.TagID).First(&t).RecordNotFound() {
+			return "", nil
+		}
+		targs := TagArgs{GroupID: j.GroupID, ContactID: c.ID, UserID: j.UserID}
+		if j.Action == BulkRemoveTag {
+			return "", TagStore(db).Save(&t, targs)
+		}
+		if !containsID(c.TagIDs, t.ID) {
+			return "", nil
+		}
+		return "", TagStore(db).Delete(&t, targs)
+	case BulkAddMission:
+		if !containsID(c.MissionIDs, j.MissionID) {
+			return "", nil
+		}
+		return "", MissionStore(db).RemoveContact(MissionArgs{UserID: j.UserID, Mission: &Mission{ID: j.MissionID, GroupID: j.GroupID}}, c.ID)
+	case BulkSetOwner:
+		c.UserID = change.OwnerID
+		c.Formdatas = nil
+		return "", contactStore.Save(c, cargs)
+	case BulkSetFormdata:
+		var ops []Formdata
+		for _, id := range change.CreatedIDs {
+			ops = append(ops, Formdata{ID: id, Op: FormdataRemove})
+		}
+		c.Formdatas = ops
+		if err = contactStore.Save(c, cargs); err != nil {
+			return "", err
+		}
+		targs.Type = TrashFormdata
+		for _, id := range change.DeletedIDs {
+			targs.ID = id
+			if err = TrashStore(db).Restore(targs); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+	case BulkImport:
+		return "", contactStore.Delete(c, cargs)
+	}
+
+	return "", fmt.Errorf("unknown action %q", j.Action)
+}
+
+// conflict returns why the change recorded on an item can't be rolled back, when a later edit of the contact
+// touched what the action set. An imported contact can't be edited at all since the import.
+func (j *BulkJob) conflict(db *gorm.DB, c *Contact, change BulkChange) string {
+	switch j.Action {
+	case BulkAddTag:
+		if containsID(c.TagIDs, j.TagID) && j.editedSince(db, "tag", j.TagID, c.ID) {
+			return "tag was removed and added again since"
+		}
+	case BulkRemoveTag:
+		if db.Where("group_id = ? AND id = ?", j.GroupID, j.TagID).First(&Tag{}).RecordNotFound() {
+			return "tag was removed from the group since"
+		}
+		if !containsID(c.TagIDs, j.TagID) && j.editedSince(db, "tag", j.TagID, c.ID) {
+			return "tag was added and removed again since"
+		}
+	case BulkAddMission:
+		if containsID(c.MissionIDs, j.MissionID) && j.editedSince(db, "mission", j.MissionID, c.ID) {
+			return "contact was removed from the mission and added again since"
+		}
+	case BulkSetOwner:
+		if c.UserID != j.OwnerID {
+			return "owner was changed since"
+		}
+	case BulkSetFormdata:
+		var answers []uint
+		for _, f := range c.Formdatas {
+			if f.FormID == j.FormID {
+				if !containsID(change.CreatedIDs, f.ID) || f.Data != j.Data {
+					return "answer was changed since"
+				}
+				answers = append(answers, f.ID)
+			}
+		}
+		if len(answers) != len(change.CreatedIDs) {
+			return "answer was removed since"
+		}
+	case BulkImport:
+		if c.Version != change.Version {
+			return fmt.Sprintf("contact was edited since (version %d, now %d)", change.Version, c.Version)
+		}
+	}
+
+	return ""
+}
+
+// editedSince tells whether the audit log holds a change of the tag or the mission membership of a contact made
+// after the job, the version of the contact being left as it is by these changes
+func (j *BulkJob) editedSince(db *gorm.DB, entity string, id uint, contactID uint) bool {
+	if j.Finished == nil {
+		return false
+	}
+
+	var n int
+	db.Model(&AuditEntry{}).Where("group_id = ? AND entity = ? AND entity_id = ? AND contact_id = ? AND date > ?", j.GroupID, entity, id, contactID, *j.Finished).Count(&n)
+	return n > 0
+}
+
+// containsID tells whether an ID is in the list
+func containsID(ids []uint, id uint) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,20 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "github.com/jinzhu/gorm"
+
+// BulkDS implements the BulkSQL methods
+type BulkDS interface {
+	Create(*BulkJob, BulkArgs) error
+	First(BulkArgs) (*BulkJob, error)
+	Find(BulkArgs) ([]BulkJob, error)
+	Unfinished() ([]BulkJob, error)
+	RunBatch(*BulkJob) ([]uint, error)
+	Imported(BulkArgs) (*BulkJob, error)
+	Rollback(*BulkJob, bool) ([]uint, error)
+}
+
+// BulkStore returns a BulkDS implementing the bulk job methods and containing a gorm client
+func BulkStore(db *gorm.DB) BulkDS {
+	return &BulkSQL{DB: db}
+}
@@ -0,0 +1,82 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "time"
+
+// Channels a contact can consent to be reached through
+const (
+	ConsentEmail = "email"
+	ConsentSMS   = "sms"
+	ConsentPhone = "phone"
+	ConsentPost  = "post"
+)
+
+// ConsentChannels lists the channels in the order they are displayed
+var ConsentChannels = []string{ConsentEmail, ConsentSMS, ConsentPhone, ConsentPost}
+
+// LegalBases are the lawful bases of processing of the GDPR (article 6) a consent record can rely on
+var LegalBases = []string{"consent", "contract", "legal_obligation", "vital_interests", "public_task", "legitimate_interests"}
+
+// Consent records the permission given or withdrawn by a contact to be reached through a channel.
+// Records are never updated: the latest one of a channel holds the current state, the others its history.
+type Consent struct {
+	ID         uint      `gorm:"primary_key" json:"id"`
+	GroupID    uint      `sql:"not null;index" json:"group_id"`
+	ContactID  uint      `sql:"not null;index" json:"contact_id"`
+	Channel    string    `sql:"not null" json:"channel"`
+	Granted    bool      `json:"granted"`
+	LegalBasis string    `sql:"not null" json:"legal_basis"`
+	Source     string    `json:"source,omitempty"` // as "door to door", "web form" or "phone call"
+	Date       time.Time `json:"date"`
+	UserID     uint      `json:"user_id,omitempty"` // who recorded it
+}
+
+// ConsentArgs is used in the RPC communications between the gateway and Contacts
+type ConsentArgs struct {
+	GroupID   uint
+	ContactID uint
+	UserID    uint
+	Consent   *Consent
+}
+
+// ConsentReply is used in the RPC communications between the gateway and Contacts
+type ConsentReply struct {
+	Consent  *Consent
+	Consents []Consent
+}
+
+// StripUnconsented removes from the contact the emails, phone numbers and addresses of the channels
+// it didn't consent to, according to c.Consents, and reports whether it can still be reached at all.
+// Mobile numbers are kept for either calls or SMS.
+func (c *Contact) StripUnconsented() bool {
+	var (
+		email  = c.Consents[ConsentEmail]
+		phone  = c.Consents[ConsentPhone]
+		mobile = phone || c.Consents[ConsentSMS]
+		post   = c.Consents[ConsentPost]
+	)
+
+	if !email {
+		c.Mail = nil
+	}
+	if !phone {
+		c.Phone = nil
+	}
+	if !mobile {
+		c.Mobile = nil
+	}
+	if !post {
+		c.Address = Address{ID: c.Address.ID}
+		c.Addresses = nil
+	}
+
+	var methods []ContactMethod
+	for _, m := range c.Methods {
+		if (m.Kind == MethodEmail && email) || (m.Kind == MethodPhone && phone) || (m.Kind == MethodMobile && mobile) {
+			methods = append(methods, m)
+		}
+	}
+	c.Methods = methods
+
+	return email || phone || mobile || post
+}
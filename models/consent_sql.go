@@ -0,0 +1,152 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ConsentSQL contains a Gorm client and the consent and gorm related methods
+type ConsentSQL struct {
+	DB *gorm.DB
+}
+
+// Save appends a consent record to the history of a contact
+func (s *ConsentSQL) Save(c *Consent, args ConsentArgs) error {
+	if c == nil {
+		return errors.New("save: consent is nil")
+	}
+	if c.ContactID == 0 {
+		return errors.New("save: consent has no contact")
+	}
+	if !contains(ConsentChannels, c.Channel) {
+		return fmt.Errorf("save: unknown consent channel %q", c.Channel)
+	}
+	if !contains(LegalBases, c.LegalBasis) {
+		return fmt.Errorf("save: unknown legal basis %q", c.LegalBasis)
+	}
+
+	var n int
+	if err := s.DB.Model(&Contact{}).Where("group_id = ? AND id = ?", args.GroupID, c.ContactID).Count(&n).Error; err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("save: contact not found")
+	}
+
+	c.ID = 0
+	c.GroupID = args.GroupID
+	c.UserID = args.UserID
+	if c.Date.IsZero() {
+		c.Date = time.Now()
+	}
+
+	// the contact changes as well for the address books synchronizing it, see FindModifiedSince
+	tx := s.DB.Begin()
+	if err := tx.Create(c).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := touchContacts(tx, c.GroupID, c.ContactID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// Find returns the consent history of a contact, newest first
+func (s *ConsentSQL) Find(args ConsentArgs) ([]Consent, error) {
+	var consents []Consent
+
+	err := s.DB.Where("group_id = ? AND contact_id = ?", args.GroupID, args.ContactID).Order("date desc, id desc").Find(&consents).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return consents, nil
+}
+
+// Current returns the latest consent record of every channel of a contact
+func (s *ConsentSQL) Current(args ConsentArgs) ([]Consent, error) {
+	var (
+		current  []Consent
+		channels = make(map[string]bool)
+	)
+
+	history, err := s.Find(args)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range history {
+		if !channels[c.Channel] {
+			channels[c.Channel] = true
+			current = append(current, c)
+		}
+	}
+
+	return current, nil
+}
+
+// Allowed returns the contacts among ids which can currently be reached through the channel;
+// the contacts without any record on the channel are not.
+func (s *ConsentSQL) Allowed(args ConsentArgs, channel string, ids []uint) ([]uint, error) {
+	var (
+		allowed  []uint
+		consents []Consent
+		seen     = make(map[uint]bool)
+	)
+
+	if len(ids) == 0 {
+		return allowed, nil
+	}
+
+	err := s.DB.Where("group_id = ? AND channel = ? AND contact_id IN (?)", args.GroupID, channel, ids).Order("date desc, id desc").Find(&consents).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range consents {
+		if seen[c.ContactID] {
+			continue
+		}
+		seen[c.ContactID] = true
+		if c.Granted {
+			allowed = append(allowed, c.ContactID)
+		}
+	}
+
+	return allowed, nil
+}
+
+// consentsOf returns the current consent of a contact by channel, as indexed for the searches
+func consentsOf(db *gorm.DB, c *Contact) (map[string]bool, error) {
+	current, err := (&ConsentSQL{DB: db}).Current(ConsentArgs{GroupID: c.GroupID, ContactID: c.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	consents := make(map[string]bool)
+	for _, channel := range ConsentChannels {
+		consents[channel] = false
+	}
+	for _, consent := range current {
+		consents[consent.Channel] = consent.Granted
+	}
+
+	return consents, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
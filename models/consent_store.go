@@ -0,0 +1,17 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "github.com/jinzhu/gorm"
+
+// ConsentDS implements the ConsentSQL methods
+type ConsentDS interface {
+	Save(*Consent, ConsentArgs) error
+	Find(ConsentArgs) ([]Consent, error)
+	Current(ConsentArgs) ([]Consent, error)
+	Allowed(ConsentArgs, string, []uint) ([]uint, error)
+}
+
+// ConsentStore returns a ConsentDS implementing CRUD methods for the consents and containing a gorm client
+func ConsentStore(db *gorm.DB) ConsentDS {
+	return &ConsentSQL{DB: db}
+}
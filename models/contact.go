@@ -2,9 +2,9 @@
 package models
 
 import (
+	"fmt"
+	"strings"
 	"time"
-
-	// "github.com/asaskevich/govalidator"
 )
 
 // Address represents all the components of a contact's address
@@ -47,6 +47,7 @@ type Contact struct {
 
 	LastChange    *time.Time `json:"lastchange,omitempty"`
 	LastChangeUserID    uint `json:"lastchangeuserid,omitempty"`
+	Version       uint       `sql:"not null;default:1" json:"version"` // to be sent back on update, see ConflictError
 
 	GroupID uint `sql:"not null" db:"group_id" json:"group_id"`
 	UserID uint `db:"user_id" json:"user_id,omitempty"`
@@ -56,11 +57,28 @@ type Contact struct {
 	Notes     []Note     `json:"notes,omitempty"`
 	Tags      []Tag      `json:"tags,omitempty" gorm:"many2many:contact_tags;"`
 	Formdatas []Formdata `json:"formdatas,omitempty"`
+
+	// all the emails, phones and addresses, the flat fields above hold the primary ones
+	Methods   []ContactMethod  `json:"methods,omitempty"`
+	Addresses []ContactAddress `json:"addresses,omitempty"`
+
+	// current consent by channel, indexed to filter the searches, see Consent
+	Consents map[string]bool `sql:"-" json:"consents,omitempty"`
+
+	// tags and missions of the contact, indexed to filter the searches
+	TagIDs     []uint `sql:"-" json:"tag_ids,omitempty"`
+	MissionIDs []uint `sql:"-" json:"mission_ids,omitempty"`
+
+	// running lease on the building of the contact, set on the search results, see Lease
+	Lease *Lease `sql:"-" json:"lease,omitempty"`
+
+	DeletedAt *time.Time `sql:"index" json:"deleted_at,omitempty"`
 }
 
 // ContactArgs is used in the RPC communications between the gateway and Contacts
 type ContactArgs struct {
 	MissionID uint
+	UserID    uint
 	Contact   *Contact
 }
 
@@ -68,6 +86,7 @@ type ContactArgs struct {
 type ContactReply struct {
 	Contact  *Contact
 	Contacts []Contact
+	Errors   map[string]string // by field, the contact was not saved if its group validates strictly
 }
 
 type Geometry struct {
@@ -94,21 +113,82 @@ type Ban struct {
 	Version     string
 }
 
-// Validate checks if the contact is valid
+// Validate normalizes the fields of the contact which can be, the phone numbers to E.164 for instance,
+// and returns the reasons the others are not valid by field
 func (c *Contact) Validate() map[string]string {
 	var errs = make(map[string]string)
 
-	// if c.Firstname == "" {
-	// 	errs["firstname"] = "is required"
-	// }
-
-	// if c.Surname == "" {
-	// 	errs["surname"] = "is required"
-	// }
-
-	// if c.Mail != nil && !govalidator.IsEmail(*c.Mail) {
-	// 	errs["mail"] = "is not valid"
-	// }
+	if c.Mail != nil && strings.TrimSpace(*c.Mail) != "" {
+		if mail, ok := normalizeMail(*c.Mail); ok {
+			c.Mail = &mail
+		} else {
+			errs["mail"] = "is not valid"
+		}
+	}
+
+	for field, phone := range map[string]*string{"phone": c.Phone, "mobile": c.Mobile} {
+		if phone == nil || strings.TrimSpace(*phone) == "" {
+			continue
+		}
+		if n, ok := normalizePhone(*phone); ok {
+			*phone = n
+		} else {
+			errs[field] = "is not a valid phone number"
+		}
+	}
+
+	if c.Gender != nil && strings.TrimSpace(*c.Gender) != "" {
+		if g, ok := normalizeGender(*c.Gender); ok {
+			c.Gender = &g
+		} else {
+			errs["gender"] = "is not one of M, F"
+		}
+	}
+
+	if c.Birthdate != nil {
+		if err := checkBirthdate(*c.Birthdate); err != nil {
+			errs["birthdate"] = err.Error()
+		}
+	}
+
+	if c.Address.PostalCode != "" {
+		if code, ok := normalizePostalCode(c.Address.PostalCode, c.Address.Country); ok {
+			c.Address.PostalCode = code
+		} else {
+			errs["address.postalcode"] = "is not a valid postal code"
+		}
+	}
+
+	for i := range c.Methods {
+		var (
+			m  = &c.Methods[i]
+			ok bool
+		)
+		switch m.Kind {
+		case MethodEmail:
+			m.Value, ok = normalizeMail(m.Value)
+		case MethodPhone, MethodMobile:
+			m.Value, ok = normalizePhone(m.Value)
+		default:
+			errs[fmt.Sprintf("methods.%d.kind", i)] = "is not one of email, phone, mobile"
+			continue
+		}
+		if !ok {
+			errs[fmt.Sprintf("methods.%d.value", i)] = "is not valid"
+		}
+		m.Status = channelStatus(m.Status, ok)
+	}
+
+	for i := range c.Addresses {
+		a := &c.Addresses[i].Address
+		ok := a.PostalCode == ""
+		if !ok {
+			if a.PostalCode, ok = normalizePostalCode(a.PostalCode, a.Country); !ok {
+				errs[fmt.Sprintf("addresses.%d.address.postalcode", i)] = "is not a valid postal code"
+			}
+		}
+		c.Addresses[i].Status = channelStatus(c.Addresses[i].Status, ok)
+	}
 
 	return errs
 }
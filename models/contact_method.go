@@ -0,0 +1,186 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "time"
+
+// Kinds of contact methods, each kind has at most one primary method copied into the flat fields of the contact
+const (
+	MethodEmail  = "email"  // Contact.Mail
+	MethodPhone  = "phone"  // Contact.Phone
+	MethodMobile = "mobile" // Contact.Mobile
+)
+
+// Validity statuses of the contact methods and addresses, Validate sets the first two,
+// the others are reported by the clients and kept as is
+const (
+	ChannelValid       = "valid"
+	ChannelInvalid     = "invalid"
+	ChannelBounced     = "bounced"
+	ChannelUnreachable = "unreachable"
+)
+
+// ContactMethod represents an email address or a phone number of a contact
+type ContactMethod struct {
+	ID        uint       `gorm:"primary_key" json:"id"`
+	ContactID uint       `sql:"index" json:"contact_id"`
+	GroupID   uint       `json:"group_id"`
+	Kind      string     `sql:"not null" json:"kind"`  // MethodEmail, MethodPhone or MethodMobile
+	Type      string     `json:"type,omitempty"`       // as "home", "work" or "personal"
+	Value     string     `sql:"not null" json:"value"` // normalized, see Contact.Validate
+	Primary   bool       `json:"primary"`              // the one of its kind shown in the flat fields
+	Status    string     `json:"status,omitempty"`     // ChannelValid, ChannelInvalid...
+	Source    string     `json:"source,omitempty"`     // where it comes from, as "form", "vcard" or "legacy"
+	Date      *time.Time `json:"date,omitempty"`       // when it was added
+}
+
+// ContactAddress represents a postal address of a contact, its residence, its workplace...
+type ContactAddress struct {
+	ID        uint    `gorm:"primary_key" json:"id"`
+	ContactID uint    `sql:"index" json:"contact_id"`
+	GroupID   uint    `json:"group_id"`
+	Type      string  `json:"type,omitempty"` // as "home", "work" or "secondary"
+	Primary   bool    `json:"primary"`        // the one shown in Contact.Address
+	Status    string  `json:"status,omitempty"`
+	Source    string  `json:"source,omitempty"`
+	Address   Address `json:"address"`
+	AddressID uint    `json:"-"`
+}
+
+// methodDefaultTypes is the type given to the methods created from the flat fields
+var methodDefaultTypes = map[string]string{MethodEmail: "personal", MethodPhone: "home", MethodMobile: "personal"}
+
+// syncChannels keeps the flat fields and the primary methods and addresses of the contact in step.
+// Clients unaware of the collections only send the flat fields: they are then carried over
+// to the primary entries of the current collections, given by before (nil for a new contact).
+func (c *Contact) syncChannels(before *Contact) {
+	if c.Methods == nil {
+		if before != nil {
+			c.Methods = append([]ContactMethod(nil), before.Methods...)
+		}
+		c.Methods = setPrimaryMethod(c.Methods, MethodEmail, c.Mail)
+		c.Methods = setPrimaryMethod(c.Methods, MethodPhone, c.Phone)
+		c.Methods = setPrimaryMethod(c.Methods, MethodMobile, c.Mobile)
+	}
+	if c.Addresses == nil {
+		if before != nil {
+			c.Addresses = append([]ContactAddress(nil), before.Addresses...)
+		}
+		c.Addresses = setPrimaryAddress(c.Addresses, c.Address)
+	}
+
+	now := time.Now()
+	for i := range c.Methods {
+		c.Methods[i].GroupID = c.GroupID
+		if c.Methods[i].Date == nil {
+			c.Methods[i].Date = &now
+		}
+	}
+	for i := range c.Addresses {
+		c.Addresses[i].GroupID = c.GroupID
+	}
+
+	c.Mail, c.Phone, c.Mobile = nil, nil, nil
+	for _, kind := range []string{MethodEmail, MethodPhone, MethodMobile} {
+		p := primaryMethod(c.Methods, kind)
+		if p < 0 {
+			continue
+		}
+		value := c.Methods[p].Value
+		switch kind {
+		case MethodEmail:
+			c.Mail = &value
+		case MethodPhone:
+			c.Phone = &value
+		case MethodMobile:
+			c.Mobile = &value
+		}
+	}
+
+	// the contact keeps its own address row, a copy of the primary address
+	addressID := c.AddressID
+	c.Address = Address{}
+	if p := primaryAddress(c.Addresses); p >= 0 {
+		c.Address = c.Addresses[p].Address
+	}
+	c.Address.ID = addressID
+}
+
+// primaryMethod flags a single primary method of the kind, the last one flagged by the client
+// or the first one when none is, and returns its index or -1 if the contact has no method of this kind
+func primaryMethod(methods []ContactMethod, kind string) int {
+	p := -1
+
+	for i := range methods {
+		if methods[i].Kind != kind {
+			continue
+		}
+		if p < 0 || methods[i].Primary {
+			p = i
+		}
+	}
+	for i := range methods {
+		if methods[i].Kind == kind {
+			methods[i].Primary = i == p
+		}
+	}
+
+	return p
+}
+
+// primaryAddress flags a single primary address, the last one flagged by the client
+// or the first one when none is, and returns its index or -1
+func primaryAddress(addresses []ContactAddress) int {
+	p := -1
+
+	for i := range addresses {
+		if p < 0 || addresses[i].Primary {
+			p = i
+		}
+	}
+	for i := range addresses {
+		addresses[i].Primary = i == p
+	}
+
+	return p
+}
+
+// setPrimaryMethod replaces the value of the primary method of the kind, adding it if missing
+// or removing it if the value is empty
+func setPrimaryMethod(methods []ContactMethod, kind string, value *string) []ContactMethod {
+	p := primaryMethod(methods, kind)
+
+	switch {
+	case value == nil || *value == "":
+		if p >= 0 {
+			methods = append(methods[:p], methods[p+1:]...)
+		}
+	case p < 0:
+		methods = append(methods, ContactMethod{Kind: kind, Type: methodDefaultTypes[kind], Value: *value, Primary: true, Source: "legacy"})
+	case methods[p].Value != *value:
+		methods[p].Value, methods[p].Status = *value, ""
+	}
+
+	return methods
+}
+
+// setPrimaryAddress replaces the primary address, adding it if missing or removing it if the address is empty
+func setPrimaryAddress(addresses []ContactAddress, a Address) []ContactAddress {
+	p := primaryAddress(addresses)
+	a.ID = 0
+
+	switch {
+	case a == (Address{}):
+		if p >= 0 {
+			addresses = append(addresses[:p], addresses[p+1:]...)
+		}
+	case p < 0:
+		addresses = append(addresses, ContactAddress{Type: "home", Primary: true, Source: "legacy", Address: a})
+	default:
+		a.ID = addresses[p].Address.ID
+		if addresses[p].Address != a {
+			addresses[p].Address, addresses[p].Status = a, ""
+		}
+	}
+
+	return addresses
+}
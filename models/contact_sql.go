@@ -3,6 +3,9 @@ package models
 
 import (
 	"errors"
+	"fmt"
+	"time"
+
 	"github.com/jinzhu/gorm"
 )
 
@@ -18,61 +21,328 @@ func (s *ContactSQL) Save(c *Contact, args ContactArgs) error {
 	}
 
 	c.GroupID = args.Contact.GroupID
+
+	var before *Contact
+	if c.ID != 0 {
+		var err error
+		if before, err = s.First(ContactArgs{Contact: &Contact{ID: c.ID, GroupID: args.Contact.GroupID}}); err != nil {
+			return err
+		}
+	}
+
+	c.syncChannels(before)
+	if errs := c.Validate(); len(errs) > 0 {
+		strict, err := strictValidation(s.DB, c.GroupID)
+		if err != nil {
+			return err
+		}
+		if strict {
+			return &ValidationError{Fields: errs}
+		}
+	}
+
+	if err := catalogueTags(s.DB, c); err != nil {
+		return err
+	}
+
+	// the answers are checked against the forms of the group whatever its validation mode
+	errs, err := checkFormdatas(s.DB, c.GroupID, c.Formdatas)
+	if err != nil {
+		return err
+	}
+	answers, err := c.answersAfter(before)
+	if err != nil {
+		return err
+	}
+	if err = checkRequired(s.DB, c.GroupID, answers, errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+
+	// the address books synchronizing the contact tell its changes by this date, see FindModifiedSince
+	now := time.Now()
+	c.LastChange = &now
+
 	if c.ID == 0 {
-		err := s.DB.Create(c).Error
-		s.DB.Last(c)
-		return err
-	}
-
-	// pour les formulaires à détruire lorsque le champ "Data" est mis à la valeur "ToDelete"
-	for i := len(c.Formdatas) - 1; i >= 0; i-- {
-    formdata := c.Formdatas[i]
-
-    // Condition to decide if current element has to be deleted:
-    // if (formdata.Data=="ToDelete"){
-		// 	if (formdata.ID>0){
-		// 		if err := s.DB.Delete(c.Formdatas[i]).Error; err != nil {
-		// 			return err
-		// 		}else{
-		// 			s := c.Formdatas
-		// 	    s = append(s[:i], s[i+1:]...)
-		// 	    c.Formdatas = s
-		// 		}
-		//    }else{
-		// 	 logs.Error("ENTER TO DELETE WITHOUT ID- contacter le support")
-		// 	 return errors.New("ENTER TO DELETE - contacter le support")
-		//  }
-    // }
-		c.Formdatas[i].ID=0
-		if (formdata.Data=="ToDelete"){
-					s := c.Formdatas
-			    s = append(s[:i], s[i+1:]...)
-			    c.Formdatas = s
-    }
-
-	}
-	return s.DB.Where("group_id = ?", args.Contact.GroupID).Save(c).Error
-}
-
-// Delete removes a contact from the database
+		for i := range c.Formdatas {
+			c.Formdatas[i].GroupID = c.GroupID
+			c.Formdatas[i].Version = 1
+		}
+		c.Version = 1
+		tx := s.DB.Begin()
+		if err := tx.Create(c).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := c.auditTags(tx, nil, args); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := recordAudit(tx, c.auditEntry(AuditCreate, args), nil, c); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit().Error
+	}
+
+	// the answers are saved by their own operations rather than along with the contact
+	changes := c.Formdatas
+	c.Formdatas = nil
+
+	tx := s.DB.Begin()
+	if err := bumpVersion(tx, &Contact{}, "contact", args.Contact.GroupID, c.ID, c.Version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	c.Version++
+	if err := tx.Where("group_id = ?", args.Contact.GroupID).Save(c).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := pruneChannels(tx, c); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := applyFormdatas(tx, c, before, changes, args); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := c.auditTags(tx, before, args); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := recordAudit(tx, c.auditEntry(AuditUpdate, args), before, c); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// auditTags records the tags the contact did not have before its save, gorm adding them along with it
+func (c *Contact) auditTags(db *gorm.DB, before *Contact, args ContactArgs) error {
+	var (
+		userID = c.auditEntry(AuditUpdate, args).UserID
+		seen   = make(map[uint]bool)
+	)
+
+	for i := range c.Tags {
+		t := c.Tags[i]
+		if t.ID == 0 || seen[t.ID] || (before != nil && containsID(before.TagIDs, t.ID)) {
+			continue
+		}
+		seen[t.ID] = true
+		if err := recordAudit(db, t.auditEntry(AuditCreate, TagArgs{GroupID: c.GroupID, ContactID: c.ID, UserID: userID}), nil, &t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// answersAfter returns the answers the contact will have once the operations on its formdatas applied
+func (c *Contact) answersAfter(before *Contact) ([]Formdata, error) {
+	var (
+		current = make(map[uint]Formdata)
+		answers []Formdata
+	)
+
+	if before != nil {
+		for _, f := range before.Formdatas {
+			current[f.ID] = f
+		}
+	}
+
+	for _, f := range c.Formdatas {
+		switch f.op() {
+		case FormdataAdd:
+			answers = append(answers, f)
+		case FormdataUpdate, FormdataRemove:
+			if _, ok := current[f.ID]; !ok {
+				return nil, fmt.Errorf("save: formdata %d is not an answer of the contact", f.ID)
+			}
+			if f.op() == FormdataRemove {
+				delete(current, f.ID)
+			} else {
+				current[f.ID] = f
+			}
+		default:
+			return nil, fmt.Errorf("save: unknown formdata operation %q", f.Op)
+		}
+	}
+
+	for _, f := range current {
+		answers = append(answers, f)
+	}
+
+	return answers, nil
+}
+
+// applyFormdatas applies the operations on the formdatas of a contact, the updates leaving the same answer untouched.
+// An update of an answer changed since the client read it returns a *ConflictError.
+func applyFormdatas(db *gorm.DB, c *Contact, before *Contact, changes []Formdata, args ContactArgs) error {
+	var (
+		current = make(map[uint]Formdata)
+		fargs   = FormdataArgs{UserID: args.UserID}
+	)
+
+	if fargs.UserID == 0 {
+		fargs.UserID = c.LastChangeUserID
+	}
+	for _, f := range before.Formdatas {
+		current[f.ID] = f
+	}
+
+	for i := range changes {
+		f := &changes[i]
+		f.GroupID, f.ContactID = c.GroupID, c.ID
+		old := current[f.ID]
+
+		switch f.op() {
+		case FormdataAdd:
+			f.ID, f.Version = 0, 1
+			if err := db.Create(f).Error; err != nil {
+				return err
+			}
+			if err := recordAudit(db, f.auditEntry(AuditCreate, fargs), nil, f); err != nil {
+				return err
+			}
+		case FormdataUpdate:
+			if f.sameAnswer(&old) {
+				continue
+			}
+			// the client sends back the version it read, the answer may have been changed since
+			if err := bumpVersion(db, &Formdata{}, "formdata", c.GroupID, f.ID, f.Version); err != nil {
+				return err
+			}
+			f.Version, f.DeletedAt = f.Version+1, nil
+			if err := db.Save(f).Error; err != nil {
+				return err
+			}
+			if err := recordAudit(db, f.auditEntry(AuditUpdate, fargs), &old, f); err != nil {
+				return err
+			}
+		case FormdataRemove:
+			if err := db.Delete(&old).Error; err != nil {
+				return err
+			}
+			if err := recordAudit(db, old.auditEntry(AuditDelete, fargs), &old, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	return db.Where("contact_id = ?", c.ID).Find(&c.Formdatas).Error
+}
+
+// pruneChannels removes the methods and addresses which are no longer in the collections of the contact
+func pruneChannels(db *gorm.DB, c *Contact) error {
+	var (
+		methodIDs  = []uint{0}
+		addressIDs = []uint{0}
+		orphans    []uint
+	)
+
+	for _, m := range c.Methods {
+		methodIDs = append(methodIDs, m.ID)
+	}
+	for _, a := range c.Addresses {
+		addressIDs = append(addressIDs, a.ID)
+	}
+
+	if err := db.Where("contact_id = ? AND id NOT IN (?)", c.ID, methodIDs).Delete(&ContactMethod{}).Error; err != nil {
+		return err
+	}
+
+	orphaned := db.Model(&ContactAddress{}).Where("contact_id = ? AND id NOT IN (?)", c.ID, addressIDs)
+	if err := orphaned.Pluck("address_id", &orphans).Error; err != nil {
+		return err
+	}
+	if err := orphaned.Delete(&ContactAddress{}).Error; err != nil {
+		return err
+	}
+	if len(orphans) > 0 {
+		return db.Where("id IN (?)", orphans).Delete(&Address{}).Error
+	}
+
+	return nil
+}
+
+// Delete moves a contact, its notes and its formdatas to the trash
 func (s *ContactSQL) Delete(c *Contact, args ContactArgs) error {
 	if c == nil {
 		return errors.New("delete: contact is nil")
 	}
+	if c.ID == 0 {
+		return errors.New("delete: contact has no ID")
+	}
+
+	before, err := s.First(ContactArgs{Contact: &Contact{ID: c.ID, GroupID: args.Contact.GroupID}})
+	if err != nil || before == nil {
+		return err
+	}
+
+	// the dependents share the contact's deletion date, so that restoring the contact only brings back what went with it
+	var (
+		now = time.Now()
+		tx  = s.DB.Begin()
+	)
 
-	return s.DB.Where("group_id = ?", args.Contact.GroupID).Delete(c).Error
+	if err := tx.Model(&Note{}).Where("group_id = ? AND contact_id = ?", args.Contact.GroupID, c.ID).UpdateColumn("deleted_at", now).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Model(&Formdata{}).Where("group_id = ? AND contact_id = ?", args.Contact.GroupID, c.ID).UpdateColumn("deleted_at", now).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Model(&Fact{}).Where("group_id = ? AND contact_id = ?", args.Contact.GroupID, c.ID).UpdateColumn("deleted_at", now).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Model(&Contact{}).Where("group_id = ? AND id = ?", args.Contact.GroupID, c.ID).UpdateColumn("deleted_at", now).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := recordAudit(tx, before.auditEntry(AuditDelete, args), before, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// auditEntry returns the audit log entry of a change made to the contact
+func (c *Contact) auditEntry(action string, args ContactArgs) AuditEntry {
+	userID := args.UserID
+	if userID == 0 {
+		userID = c.LastChangeUserID
+	}
+
+	return AuditEntry{GroupID: c.GroupID, Entity: "contact", EntityID: c.ID, ContactID: c.ID, Action: action, UserID: userID}
 }
 
 // First returns a contact from the database using his ID
 func (s *ContactSQL) First(args ContactArgs) (*Contact, error) {
 	var c Contact
 
-	if err := s.DB.Where(args.Contact).Preload("Address").Preload("Formdatas").First(&c).Error; err != nil {
+	if err := s.DB.Where(args.Contact).Preload("Address").Preload("Formdatas").Preload("Methods").Preload("Addresses.Address").First(&c).Error; err != nil {
 		if s.DB.Where(args.Contact).First(&c).RecordNotFound() {
 			return nil, nil
 		}
 		return nil, err
 	}
+
+	var err error
+	if c.Consents, err = consentsOf(s.DB, &c); err != nil {
+		return nil, err
+	}
+	if c.TagIDs, c.MissionIDs, err = relationIDs(s.DB, c.ID); err != nil {
+		return nil, err
+	}
+
 	// ancienne méthode permettant d'ajouter l'address au contact. Maintenant c'est fait avec Preload
 
 	// if err := s.DB.Where(c.AddressID).First(&c.Address).Error; err != nil {
@@ -85,6 +355,20 @@ func (s *ContactSQL) First(args ContactArgs) (*Contact, error) {
 	return &c, nil
 }
 
+// relationIDs returns the IDs of the tags and of the missions of a contact, the missions in the trash left out
+func relationIDs(db *gorm.DB, id uint) ([]uint, []uint, error) {
+	var tagIDs, missionIDs []uint
+
+	if err := db.Table("contact_tags").Where("contact_id = ?", id).Pluck("tag_id", &tagIDs).Error; err != nil {
+		return nil, nil, err
+	}
+	if err := db.Model(&Mission{}).Where("id IN (SELECT mission_id FROM mission_contacts WHERE contact_id = ?)", id).Pluck("id", &missionIDs).Error; err != nil {
+		return nil, nil, err
+	}
+
+	return tagIDs, missionIDs, nil
+}
+
 // Find returns all the contacts with a given groupID from the database
 func (s *ContactSQL) Find(args ContactArgs) ([]Contact, error) {
 	var contacts []Contact
@@ -97,10 +381,148 @@ func (s *ContactSQL) Find(args ContactArgs) ([]Contact, error) {
 	return contacts, nil
 }
 
+// FindByIDs returns the contacts of a group matching the given IDs from the database
+func (s *ContactSQL) FindByIDs(ids []uint, args ContactArgs) ([]Contact, error) {
+	var contacts []Contact
+
+	if len(ids) == 0 {
+		return contacts, nil
+	}
+
+	err := s.DB.Where("group_id = ?", args.Contact.GroupID).Where("id in (?)", ids).Preload("Address").Preload("Methods").Preload("Addresses.Address").Find(&contacts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+// FindModifiedSince returns all the contacts of a group changed after the given date, or all of them if since is nil
+func (s *ContactSQL) FindModifiedSince(since *time.Time, args ContactArgs) ([]Contact, error) {
+	var contacts []Contact
+
+	db := s.DB.Where("group_id = ?", args.Contact.GroupID)
+	if since != nil {
+		db = db.Where("last_change > ?", *since)
+	}
+
+	if err := db.Preload("Address").Preload("Methods").Preload("Addresses.Address").Find(&contacts).Error; err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+// touchContacts sets the last change of contacts of a group to now, so that the address books synchronizing them
+// get the changes made to what their vCard shows, see FindModifiedSince
+func touchContacts(db *gorm.DB, groupID uint, ids ...uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return db.Model(&Contact{}).Where("group_id = ? AND id IN (?)", groupID, ids).UpdateColumn("last_change", time.Now()).Error
+}
+
+// FindDeletedSince returns the contacts of a group moved to the trash after the given date, or if args.MissionID is set
+// the contacts of the mission moved to the trash or taken out of the mission after it
+func (s *ContactSQL) FindDeletedSince(since time.Time, args ContactArgs) ([]Contact, error) {
+	var contacts []Contact
+
+	db := s.DB.Unscoped().Where("group_id = ? AND deleted_at > ?", args.Contact.GroupID, since)
+	if args.MissionID != 0 {
+		removed := "SELECT contact_id FROM audit_entries WHERE group_id = ? AND entity = 'mission' AND entity_id = ? AND action = ? AND contact_id <> 0 AND date > ?"
+		members := "SELECT contact_id FROM mission_contacts WHERE mission_id = ?"
+		db = s.DB.Unscoped().Where("group_id = ?", args.Contact.GroupID).
+			Where("(deleted_at > ? AND id IN ("+members+")) OR (id IN ("+removed+") AND id NOT IN ("+members+"))",
+				since, args.MissionID, args.Contact.GroupID, args.MissionID, AuditDelete, since, args.MissionID)
+	}
+
+	if err := db.Find(&contacts).Error; err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
 // FindByMission returns all the contacts from in a mission from the database
 func (s *ContactSQL) FindByMission(m *Mission, args ContactArgs) ([]Contact, error) {
 	var contacts []Contact
-	err := s.DB.Model(m).Related(&contacts, "Contacts").Error
 
-	return contacts, err
+	ids, err := MissionStore(s.DB).ContactIDs(MissionArgs{Mission: m})
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	if err = s.DB.Where("group_id = ? AND id IN (?)", m.GroupID, ids).Preload("Address").Find(&contacts).Error; err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+// MigrateChannels fills the methods and the addresses of the contacts created before the collections from their flat
+// fields, the contacts already having methods or addresses being left as they are
+func MigrateChannels(db *gorm.DB) error {
+	var lastID uint
+
+	missing := "NOT EXISTS (SELECT 1 FROM contact_methods WHERE contact_methods.contact_id = contacts.id) OR NOT EXISTS (SELECT 1 FROM contact_addresses WHERE contact_addresses.contact_id = contacts.id)"
+	for {
+		var contacts []Contact
+
+		if err := db.Unscoped().Where("id > ?", lastID).Where(missing).Preload("Address").Order("id").Limit(500).Find(&contacts).Error; err != nil {
+			return err
+		}
+		if len(contacts) == 0 {
+			return nil
+		}
+		lastID = contacts[len(contacts)-1].ID
+
+		for i := range contacts {
+			if err := migrateChannels(db, &contacts[i]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// migrateChannels creates the primary method of each flat field of the contact and its primary address, for the
+// collections it has nothing in
+func migrateChannels(db *gorm.DB, c *Contact) error {
+	var (
+		methods, addresses int
+		now                = time.Now()
+	)
+
+	if err := db.Model(&ContactMethod{}).Where("contact_id = ?", c.ID).Count(&methods).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&ContactAddress{}).Where("contact_id = ?", c.ID).Count(&addresses).Error; err != nil {
+		return err
+	}
+
+	tx := db.Begin()
+	if methods == 0 {
+		var ms []ContactMethod
+		ms = setPrimaryMethod(ms, MethodEmail, c.Mail)
+		ms = setPrimaryMethod(ms, MethodPhone, c.Phone)
+		ms = setPrimaryMethod(ms, MethodMobile, c.Mobile)
+		for _, m := range ms {
+			m.ContactID, m.GroupID, m.Date = c.ID, c.GroupID, &now
+			if err := tx.Create(&m).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	if addresses == 0 {
+		// the contact keeps its own address row, the primary address is a copy of it
+		for _, a := range setPrimaryAddress(nil, c.Address) {
+			a.ContactID, a.GroupID = c.ID, c.GroupID
+			if err := tx.Create(&a).Error; err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	return tx.Commit().Error
 }
@@ -1,7 +1,11 @@
 // Definition of the structures and SQL interaction functions
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
 
 // ContactDS implements the ContactSQL methods
 type ContactDS interface {
@@ -9,6 +13,9 @@ type ContactDS interface {
 	Delete(*Contact, ContactArgs) error
 	First(ContactArgs) (*Contact, error)
 	Find(ContactArgs) ([]Contact, error)
+	FindByIDs([]uint, ContactArgs) ([]Contact, error)
+	FindModifiedSince(*time.Time, ContactArgs) ([]Contact, error)
+	FindDeletedSince(time.Time, ContactArgs) ([]Contact, error)
 	FindByMission(*Mission, ContactArgs) ([]Contact, error)
 
 	// FindNotes(*Contact, *ContactArgs) error
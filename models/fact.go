@@ -2,6 +2,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/quorumsco/oauth2/models"
 )
 
@@ -25,12 +27,56 @@ import (
 type Fact struct {
 	ID        uint    `gorm:"primary_key" json:"id"`
 	GroupID   uint    `json:"group_id"`
-	Date      string  `json:"-"`
 	Type      string  `json:"type"`
 	Status    string  `json:"status"`
 	Contact   Contact `gorm:"save_associations:false" json:"contact"`
 	ContactID uint    `json:"contact_id"`
 	ActionID  uint    `json:"action_id"`
+
+	UserID     uint       `sql:"index" json:"user_id,omitempty"` // the author, set on creation
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	OccurredAt *time.Time `sql:"index" json:"occurred_at,omitempty"` // when it happened, its creation by default
+
+	// where the fact was recorded, as given by the device of the author
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	Location  string   `sql:"-" json:"location,omitempty"` // as "lat,lon" (for elasticsearch)
+
+	// set on creation from the location of the fact and the address of the contact, see GroupSetting.GeofenceRadius
+	Distance   *float64 `json:"distance,omitempty"` // in meters
+	Suspicious bool     `sql:"index" json:"suspicious"`
+
+	DeletedAt *time.Time `sql:"index" json:"deleted_at,omitempty"`
+}
+
+// FactIntervals lists the intervals of the date histograms of the facts
+var FactIntervals = []string{"hour", "day", "week", "month"}
+
+// FactSearch represents the filters of a search of the facts of a group, the empty ones being left out
+type FactSearch struct {
+	GroupID    uint       `json:"group_id"`
+	Start      *time.Time `json:"start,omitempty"` // on the date the facts occurred
+	End        *time.Time `json:"end,omitempty"`
+	Types      []string   `json:"types,omitempty"`
+	Statuses   []string   `json:"statuses,omitempty"`
+	ActionIDs  []uint     `json:"action_ids,omitempty"`
+	UserIDs    []uint     `json:"user_ids,omitempty"`
+	ContactIDs []uint     `json:"contact_ids,omitempty"`
+	Suspicious *bool      `json:"suspicious,omitempty"`
+
+	Interval string `json:"interval,omitempty"` // one of FactIntervals, day by default
+	From     int    `json:"from,omitempty"`
+	Size     int    `json:"size,omitempty"` // FactPageSize by default
+}
+
+// FactPageSize is the number of facts returned at once by default by a search
+const FactPageSize = 50
+
+// FactBucket represents the facts which occurred during an interval of a date histogram, counted by status
+type FactBucket struct {
+	Date     time.Time        `json:"date"`
+	Count    int64            `json:"count"`
+	Statuses map[string]int64 `json:"statuses"`
 }
 
 //To represent a GeoPolygon
@@ -55,11 +101,16 @@ type FactsJson struct {
 
 // FactArgs is used in the RPC communications between the gateway and Contacts
 type FactArgs struct {
-	Fact *Fact
+	UserID uint
+	Fact   *Fact
+	Search *FactSearch
 }
 
 // FactReply is used in the RPC communications between the gateway and Contacts
 type FactReply struct {
-	Fact  *Fact
-	Facts []Fact
+	Fact      *Fact
+	Facts     []Fact
+	Total     int64        // facts matching the search, or indexed again by Search.ReindexFacts
+	Histogram []FactBucket // facts matching the search by date
+	Geofence  *GeofenceReport
 }
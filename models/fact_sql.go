@@ -3,6 +3,12 @@ package models
 
 import (
 	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 )
@@ -19,13 +25,189 @@ func (s *FactSQL) Save(f *Fact, args FactArgs) error {
 	}
 
 	f.GroupID = args.Fact.GroupID
+	if (f.Latitude == nil) != (f.Longitude == nil) || (f.Latitude != nil && (math.Abs(*f.Latitude) > 90 || math.Abs(*f.Longitude) > 180)) {
+		return errors.New("save: the location of the fact is not valid")
+	}
+
 	if f.ID == 0 {
-		err := s.DB.Create(f).Error
-		s.DB.Last(f)
+		f.UserID = args.UserID
+		if f.OccurredAt == nil {
+			now := time.Now()
+			f.OccurredAt = &now
+		}
+		if err := s.geofence(f); err != nil {
+			return err
+		}
+		tx := s.DB.Begin()
+		if err := tx.Create(f).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		// the visit is over, the building is free again
+		if err := releaseVisited(tx, f.GroupID, f.ContactID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := recordAudit(tx, f.auditEntry(AuditCreate, args), nil, f); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit().Error
+	}
+
+	var before Fact
+	if err := s.DB.Where("group_id = ? AND id = ?", args.Fact.GroupID, f.ID).First(&before).Error; err != nil {
+		return err
+	}
+	// the author, the creation and where it took place are kept
+	f.UserID, f.CreatedAt = before.UserID, before.CreatedAt
+	f.Latitude, f.Longitude, f.Distance, f.Suspicious = before.Latitude, before.Longitude, before.Distance, before.Suspicious
+	if f.OccurredAt == nil {
+		f.OccurredAt = before.OccurredAt
+	}
+	tx := s.DB.Begin()
+	if err := tx.Where("group_id = ?", args.Fact.GroupID).Save(f).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := recordAudit(tx, f.auditEntry(AuditUpdate, args), &before, f); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// geofence sets the distance between where a new fact was recorded and the address of its contact, the fact being
+// suspicious beyond the geofence radius of the group. The distance is left unknown when either is not located.
+func (s *FactSQL) geofence(f *Fact) error {
+	var c Contact
+
+	f.Distance, f.Suspicious = nil, false
+	if f.Latitude == nil {
+		return nil
+	}
+
+	if err := s.DB.Where("group_id = ? AND id = ?", f.GroupID, f.ContactID).Preload("Address").First(&c).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", f.GroupID, f.ContactID).First(&c).RecordNotFound() {
+			return nil
+		}
+		return err
+	}
+	lat, errLat := strconv.ParseFloat(strings.TrimSpace(c.Address.Latitude), 64)
+	lng, errLng := strconv.ParseFloat(strings.TrimSpace(c.Address.Longitude), 64)
+	if errLat != nil || errLng != nil {
+		return nil
+	}
+
+	gs, err := GroupSettingStore(s.DB).First(GroupSettingArgs{GroupSetting: &GroupSetting{GroupID: f.GroupID}})
+	if err != nil {
 		return err
 	}
+	radius := gs.GeofenceRadius
+	if radius == 0 {
+		radius = DefaultGeofenceRadius
+	}
 
-	return s.DB.Where("group_id = ?", args.Fact.GroupID).Save(f).Error
+	d := greatCircle(*f.Latitude, *f.Longitude, lat, lng)
+	f.Distance, f.Suspicious = &d, d > float64(radius)
+
+	return nil
+}
+
+// Geofence reports by volunteer and by period the facts of the group matching args.Search, and the suspicious ones
+// among them. The periods are those of the interval of the search, a day by default.
+func (s *FactSQL) Geofence(args FactArgs) (*GeofenceReport, error) {
+	var (
+		facts      []Fact
+		report     = &GeofenceReport{}
+		volunteers = make(map[uint]*GeofenceVolunteer)
+		periods    = make(map[uint]map[time.Time]int)
+	)
+
+	search := args.Search
+	if search == nil {
+		return nil, errors.New("geofence: no search given")
+	}
+	interval := search.Interval
+	if interval == "" {
+		interval = "day"
+	}
+	if !contains(FactIntervals, interval) {
+		return nil, fmt.Errorf("geofence: unknown interval %q", interval)
+	}
+
+	db := s.DB.Where("group_id = ?", search.GroupID)
+	if search.Start != nil {
+		db = db.Where("occurred_at >= ?", *search.Start)
+	}
+	if search.End != nil {
+		db = db.Where("occurred_at <= ?", *search.End)
+	}
+	if len(search.Types) > 0 {
+		db = db.Where("type IN (?)", search.Types)
+	}
+	if len(search.Statuses) > 0 {
+		db = db.Where("status IN (?)", search.Statuses)
+	}
+	if len(search.ActionIDs) > 0 {
+		db = db.Where("action_id IN (?)", search.ActionIDs)
+	}
+	if len(search.UserIDs) > 0 {
+		db = db.Where("user_id IN (?)", search.UserIDs)
+	}
+	if len(search.ContactIDs) > 0 {
+		db = db.Where("contact_id IN (?)", search.ContactIDs)
+	}
+	if err := db.Where("occurred_at IS NOT NULL").Order("occurred_at, id").Find(&facts).Error; err != nil {
+		return nil, err
+	}
+
+	for _, f := range facts {
+		v := volunteers[f.UserID]
+		if v == nil {
+			v = &GeofenceVolunteer{UserID: f.UserID}
+			volunteers[f.UserID] = v
+			periods[f.UserID] = make(map[time.Time]int)
+		}
+		date := periodStart(*f.OccurredAt, interval)
+		i, ok := periods[f.UserID][date]
+		if !ok {
+			// the facts come in chronological order, and so do the periods
+			i = len(v.Periods)
+			v.Periods = append(v.Periods, GeofencePeriod{Date: date})
+			periods[f.UserID][date] = i
+		}
+		p := &v.Periods[i]
+
+		v.Facts++
+		p.Facts++
+		if f.Distance != nil {
+			v.Located++
+			p.Located++
+		}
+		if f.Suspicious {
+			v.Suspicious++
+			p.Suspicious++
+			v.FactIDs = append(v.FactIDs, f.ID)
+		}
+	}
+
+	for _, v := range volunteers {
+		if v.Located > 0 {
+			v.Rate = float64(v.Suspicious) / float64(v.Located)
+		}
+		report.Volunteers = append(report.Volunteers, *v)
+	}
+	sort.Slice(report.Volunteers, func(i, j int) bool {
+		a, b := report.Volunteers[i], report.Volunteers[j]
+		if a.Suspicious != b.Suspicious {
+			return a.Suspicious > b.Suspicious
+		}
+		return a.UserID < b.UserID
+	})
+
+	return report, nil
 }
 
 // Delete removes a fact from the database
@@ -34,7 +216,29 @@ func (s *FactSQL) Delete(f *Fact, args FactArgs) error {
 		return errors.New("delete: fact is nil")
 	}
 
-	return s.DB.Where("group_id = ?", args.Fact.GroupID).Delete(f).Error
+	var before Fact
+	if err := s.DB.Where("group_id = ? AND id = ?", args.Fact.GroupID, f.ID).First(&before).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.Fact.GroupID, f.ID).First(&before).RecordNotFound() {
+			return nil
+		}
+		return err
+	}
+	tx := s.DB.Begin()
+	if err := tx.Where("group_id = ?", args.Fact.GroupID).Delete(&before).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := recordAudit(tx, before.auditEntry(AuditDelete, args), &before, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// auditEntry returns the audit log entry of a change made to the fact
+func (f *Fact) auditEntry(action string, args FactArgs) AuditEntry {
+	return AuditEntry{GroupID: f.GroupID, Entity: "fact", EntityID: f.ID, ContactID: f.ContactID, Action: action, UserID: args.UserID}
 }
 
 // First returns a fact from the database using his ID
@@ -78,3 +282,65 @@ func (s *FactSQL) Find(args FactArgs) ([]Fact, error) {
 
 	return facts, nil
 }
+
+// FindFrom returns, by ID, at most size facts of a group following the given ID, with their contact and its address
+func (s *FactSQL) FindFrom(args FactArgs, afterID uint, size int) ([]Fact, error) {
+	var facts []Fact
+
+	err := s.DB.Where("group_id = ? AND id > ?", args.Fact.GroupID, afterID).Order("id").Limit(size).Preload("Contact").Preload("Contact.Address").Find(&facts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return facts, nil
+}
+
+// MigrateFacts sets the author and the dates of the facts created before they were recorded, from the audit log.
+// The date the facts used to be given as text is kept as their occurrence whenever it can be read.
+// The facts indexed before then have to be indexed again, see Search.ReindexFacts.
+func MigrateFacts(db *gorm.DB) error {
+	created := "SELECT %s FROM audit_entries WHERE audit_entries.entity = 'fact' AND audit_entries.action = 'create' AND audit_entries.entity_id = facts.id ORDER BY audit_entries.id LIMIT 1"
+
+	if db.NewScope(&Fact{}).Dialect().HasColumn("facts", "date") {
+		if err := migrateFactDates(db); err != nil {
+			return err
+		}
+	}
+
+	if err := db.Exec("UPDATE facts SET user_id = (" + fmt.Sprintf(created, "user_id") + ") WHERE user_id IS NULL OR user_id = 0").Error; err != nil {
+		return err
+	}
+	if err := db.Exec("UPDATE facts SET created_at = (" + fmt.Sprintf(created, "date") + ") WHERE created_at IS NULL").Error; err != nil {
+		return err
+	}
+
+	return db.Exec("UPDATE facts SET occurred_at = created_at WHERE occurred_at IS NULL").Error
+}
+
+// migrateFactDates fills the occurrence of the facts from the legacy date column
+func migrateFactDates(db *gorm.DB) error {
+	type legacy struct {
+		ID   uint
+		Date string
+	}
+	var facts []legacy
+
+	if err := db.Raw("SELECT id, date FROM facts WHERE occurred_at IS NULL AND date IS NOT NULL AND date <> ''").Scan(&facts).Error; err != nil {
+		return err
+	}
+
+	for _, f := range facts {
+		d, err := parseFormDate(f.Date)
+		if err != nil {
+			if d, err = time.Parse("02/01/2006", strings.TrimSpace(f.Date)); err != nil {
+				// left to the creation date
+				continue
+			}
+		}
+		if err = db.Model(&Fact{}).Unscoped().Where("id = ?", f.ID).UpdateColumn("occurred_at", d).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -9,6 +9,8 @@ type FactDS interface {
 	Delete(*Fact, FactArgs) error
 	First(FactArgs) (*Fact, error)
 	Find(FactArgs) ([]Fact, error)
+	FindFrom(FactArgs, uint, int) ([]Fact, error)
+	Geofence(FactArgs) (*GeofenceReport, error)
 }
 
 // Factstore returns a FactDS implementing CRUD methods for the facts and containing a gorm client
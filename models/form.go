@@ -0,0 +1,77 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "time"
+
+// Types of the form questions, as given to the form filters of the searches
+const (
+	FormText     = "TEXT"
+	FormRadio    = "RADIO"
+	FormCheckbox = "CHECKBOX"
+	FormRange    = "RANGE"
+	FormDate     = "DATE"
+)
+
+// FormTypes lists the question types
+var FormTypes = []string{FormText, FormRadio, FormCheckbox, FormRange, FormDate}
+
+// Form represents a questionnaire of a group, its version moves on every change of its questions
+type Form struct {
+	ID          uint           `gorm:"primary_key" json:"id"`
+	GroupID     uint           `sql:"not null;index" json:"group_id"`
+	Name        string         `sql:"not null" json:"name"`
+	Description string         `json:"description,omitempty"`
+	Version     uint           `sql:"not null;default:1" json:"version"`
+	Questions   []FormQuestion `json:"questions,omitempty"`
+
+	DeletedAt *time.Time `sql:"index" json:"deleted_at,omitempty"`
+}
+
+// FormQuestion represents a question of a form, the formdatas answering it carry its ID as FormID.
+// The questions removed from a form are archived rather than deleted, for the answers they already have.
+type FormQuestion struct {
+	ID       uint         `gorm:"primary_key" json:"id"`
+	FormID   uint         `sql:"index" json:"form_id"`
+	GroupID  uint         `sql:"index" json:"group_id"`
+	Label    string       `sql:"not null" json:"label"`
+	Type     string       `sql:"not null" json:"type"` // one of FormTypes
+	Required bool         `json:"required"`
+	Min      *float64     `json:"min,omitempty"` // bounds of the RANGE answers
+	Max      *float64     `json:"max,omitempty"`
+	Position uint         `json:"position"`
+	Archived bool         `json:"archived,omitempty"`
+	Choices  []FormChoice `json:"choices,omitempty"`
+}
+
+// FormChoice represents a choice of a RADIO or CHECKBOX question, the formdatas selecting it carry its ID as Form_ref_id
+type FormChoice struct {
+	ID             uint   `gorm:"primary_key" json:"id"`
+	FormQuestionID uint   `sql:"index" json:"question_id"`
+	Label          string `sql:"not null" json:"label"`
+	Position       uint   `json:"position"`
+	Archived       bool   `json:"archived,omitempty"`
+}
+
+// FormRevision keeps a form as it was before a change
+type FormRevision struct {
+	ID         uint      `gorm:"primary_key" json:"id"`
+	FormID     uint      `sql:"not null;index" json:"form_id"`
+	Version    uint      `json:"version"`
+	Date       time.Time `json:"date"`
+	UserID     uint      `json:"user_id,omitempty"`
+	Definition string    `sql:"type:text" json:"definition"` // the form as JSON
+}
+
+// FormArgs is used in the RPC communications between the gateway and Contacts
+type FormArgs struct {
+	GroupID uint
+	UserID  uint
+	Form    *Form
+}
+
+// FormReply is used in the RPC communications between the gateway and Contacts
+type FormReply struct {
+	Form      *Form
+	Forms     []Form
+	Revisions []FormRevision
+}
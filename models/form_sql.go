@@ -0,0 +1,391 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// FormSQL contains a Gorm client and the form and gorm related methods
+type FormSQL struct {
+	DB *gorm.DB
+}
+
+// Save inserts a new form into the database, or updates it after keeping its previous version as a revision.
+// The questions and choices left out of the form are archived.
+func (s *FormSQL) Save(f *Form, args FormArgs) error {
+	if f == nil {
+		return errors.New("save: form is nil")
+	}
+
+	f.GroupID = args.GroupID
+	if err := f.check(); err != nil {
+		return err
+	}
+	for i := range f.Questions {
+		f.Questions[i].GroupID = f.GroupID
+	}
+
+	if f.ID == 0 {
+		f.Version = 1
+		return s.DB.Create(f).Error
+	}
+
+	current, err := s.First(FormArgs{GroupID: args.GroupID, Form: &Form{ID: f.ID}})
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return errors.New("save: form not found")
+	}
+
+	// questions can't be moved from another form
+	owned := make(map[uint]bool)
+	for _, q := range current.Questions {
+		owned[q.ID] = true
+	}
+	for _, q := range f.Questions {
+		if q.ID != 0 && !owned[q.ID] {
+			return fmt.Errorf("save: question %d is not part of the form", q.ID)
+		}
+	}
+
+	definition, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	tx := s.DB.Begin()
+	rev := FormRevision{FormID: f.ID, Version: current.Version, Date: time.Now(), UserID: args.UserID, Definition: string(definition)}
+	if err = tx.Create(&rev).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	f.Version = current.Version + 1
+	if err = tx.Save(f).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	questionIDs := []uint{0}
+	for _, q := range f.Questions {
+		questionIDs = append(questionIDs, q.ID)
+
+		choiceIDs := []uint{0}
+		for _, c := range q.Choices {
+			choiceIDs = append(choiceIDs, c.ID)
+		}
+		if err = tx.Model(&FormChoice{}).Where("form_question_id = ? AND id NOT IN (?)", q.ID, choiceIDs).UpdateColumn("archived", true).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = tx.Model(&FormQuestion{}).Where("form_id = ? AND id NOT IN (?)", f.ID, questionIDs).UpdateColumn("archived", true).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// Delete removes a form from the database, its questions are kept for the answers they have
+func (s *FormSQL) Delete(f *Form, args FormArgs) error {
+	if f == nil {
+		return errors.New("delete: form is nil")
+	}
+
+	return s.DB.Where("group_id = ?", args.GroupID).Delete(f).Error
+}
+
+// First returns a form of a group from the database using its ID, its archived questions and choices included
+func (s *FormSQL) First(args FormArgs) (*Form, error) {
+	var f Form
+
+	if err := s.DB.Where("group_id = ? AND id = ?", args.GroupID, args.Form.ID).Preload("Questions").Preload("Questions.Choices").First(&f).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.GroupID, args.Form.ID).First(&f).RecordNotFound() {
+			return nil, nil
+		}
+		return nil, err
+	}
+	f.sort()
+
+	return &f, nil
+}
+
+// Find returns all the forms of a group from the database
+func (s *FormSQL) Find(args FormArgs) ([]Form, error) {
+	var forms []Form
+
+	err := s.DB.Where("group_id = ?", args.GroupID).Preload("Questions").Preload("Questions.Choices").Order("id").Find(&forms).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range forms {
+		forms[i].sort()
+	}
+
+	return forms, nil
+}
+
+// Revisions returns the previous versions of a form, newest first
+func (s *FormSQL) Revisions(args FormArgs) ([]FormRevision, error) {
+	var revisions []FormRevision
+
+	f, err := s.First(args)
+	if err != nil || f == nil {
+		return nil, err
+	}
+
+	if err = s.DB.Where("form_id = ?", f.ID).Order("version desc").Find(&revisions).Error; err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// QuestionTypes returns the type of the questions of a group by ID
+func (s *FormSQL) QuestionTypes(args FormArgs, ids []uint) (map[uint]string, error) {
+	var (
+		questions []FormQuestion
+		types     = make(map[uint]string)
+	)
+
+	if len(ids) == 0 {
+		return types, nil
+	}
+
+	if err := s.DB.Where("group_id = ? AND id IN (?)", args.GroupID, ids).Find(&questions).Error; err != nil {
+		return nil, err
+	}
+	for _, q := range questions {
+		types[q.ID] = q.Type
+	}
+
+	return types, nil
+}
+
+// check refuses the forms which can't be answered
+func (f *Form) check() error {
+	if strings.TrimSpace(f.Name) == "" {
+		return errors.New("save: form has no name")
+	}
+
+	for _, q := range f.Questions {
+		if strings.TrimSpace(q.Label) == "" {
+			return errors.New("save: question has no label")
+		}
+		if !contains(FormTypes, q.Type) {
+			return fmt.Errorf("save: question %q has an unknown type %q", q.Label, q.Type)
+		}
+
+		choices := 0
+		for _, c := range q.Choices {
+			if !c.Archived {
+				choices++
+			}
+		}
+		switch q.Type {
+		case FormRadio, FormCheckbox:
+			if choices == 0 {
+				return fmt.Errorf("save: question %q has no choice", q.Label)
+			}
+		default:
+			if len(q.Choices) > 0 {
+				return fmt.Errorf("save: question %q of type %s can't have choices", q.Label, q.Type)
+			}
+		}
+		if q.Min != nil && q.Max != nil && *q.Min > *q.Max {
+			return fmt.Errorf("save: question %q has a minimum above its maximum", q.Label)
+		}
+	}
+
+	return nil
+}
+
+// sort orders the questions and choices of the form by position
+func (f *Form) sort() {
+	sort.SliceStable(f.Questions, func(i, j int) bool { return f.Questions[i].Position < f.Questions[j].Position })
+	for i := range f.Questions {
+		choices := f.Questions[i].Choices
+		sort.SliceStable(choices, func(i, j int) bool { return choices[i].Position < choices[j].Position })
+	}
+}
+
+// checkAnswer returns why a formdata isn't a valid answer to the question, the field at fault first
+func (q *FormQuestion) checkAnswer(f *Formdata) (string, string) {
+	if q.Archived {
+		return "form_id", "is an archived question"
+	}
+
+	switch q.Type {
+	case FormText:
+		if q.Required && strings.TrimSpace(f.Data) == "" {
+			return "data", "is required"
+		}
+	case FormRadio, FormCheckbox:
+		for _, c := range q.Choices {
+			if c.ID == f.Form_ref_id && !c.Archived {
+				return "", ""
+			}
+		}
+		return "form_ref_id", "is not a choice of the question"
+	case FormRange:
+		n, err := strconv.ParseFloat(strings.TrimSpace(f.Data), 64)
+		if err != nil {
+			return "data", "is not a number"
+		}
+		if (q.Min != nil && n < *q.Min) || (q.Max != nil && n > *q.Max) {
+			return "data", "is out of range"
+		}
+	case FormDate:
+		if _, err := parseFormDate(f.Data); err != nil {
+			return "data", "is not a date"
+		}
+	}
+
+	return "", ""
+}
+
+// parseFormDate reads the dates answered as RFC 3339, as YYYY-MM-DD or as milliseconds since the epoch
+func parseFormDate(data string) (time.Time, error) {
+	data = strings.TrimSpace(data)
+
+	if ms, err := strconv.ParseInt(data, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), nil
+	}
+	if d, err := time.Parse(time.RFC3339, data); err == nil {
+		return d, nil
+	}
+
+	return time.Parse("2006-01-02", data)
+}
+
+// setValue fills the typed value of an answer from its data, or its data from the typed value when it is sent alone.
+// The answers to questions the group doesn't define are taken as text.
+func (n *Formdata) setValue(q *FormQuestion) {
+	qType := FormText
+	if q != nil {
+		qType = q.Type
+	}
+
+	if strings.TrimSpace(n.Data) == "" {
+		switch {
+		case qType == FormText:
+			n.Data = n.Text
+		case qType == FormRange && n.Number != nil:
+			n.Data = strconv.FormatFloat(*n.Number, 'f', -1, 64)
+		case qType == FormDate && n.DateValue != nil:
+			n.Data = strconv.FormatInt(n.DateValue.UnixNano()/int64(time.Millisecond), 10)
+		}
+	}
+
+	n.Text, n.Number, n.DateValue = "", nil, nil
+	switch qType {
+	case FormText:
+		n.Text = n.Data
+	case FormRange:
+		if number, err := strconv.ParseFloat(strings.TrimSpace(n.Data), 64); err == nil {
+			n.Number = &number
+		}
+	case FormDate:
+		if d, err := parseFormDate(n.Data); err == nil {
+			n.DateValue = &d
+		}
+	}
+}
+
+// checkFormdatas types the answers and checks them against the questions of the group, by field.
+// The answers to questions the group doesn't define are only accepted from the groups validating
+// leniently, since they predate the forms; the answers being removed are left out.
+func checkFormdatas(db *gorm.DB, groupID uint, formdatas []Formdata) (map[string]string, error) {
+	var (
+		errs      = make(map[string]string)
+		ids       []uint
+		questions []FormQuestion
+		byID      = make(map[uint]*FormQuestion)
+	)
+
+	if len(formdatas) == 0 {
+		return errs, nil
+	}
+
+	for _, f := range formdatas {
+		ids = append(ids, f.FormID)
+	}
+	if err := db.Where("group_id = ? AND id IN (?)", groupID, ids).Preload("Choices").Find(&questions).Error; err != nil {
+		return nil, err
+	}
+	for i := range questions {
+		byID[questions[i].ID] = &questions[i]
+	}
+
+	strict, err := strictValidation(db, groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range formdatas {
+		f := &formdatas[i]
+		if f.Op == FormdataRemove {
+			continue
+		}
+
+		q, ok := byID[f.FormID]
+		f.setValue(q)
+		if !ok {
+			if strict {
+				errs[fmt.Sprintf("formdatas.%d.form_id", i)] = "is not a question of the group"
+			}
+			continue
+		}
+		if field, reason := q.checkAnswer(f); field != "" {
+			errs[fmt.Sprintf("formdatas.%d.%s", i, field)] = reason
+		}
+	}
+
+	return errs, nil
+}
+
+// checkRequired adds to errs the required questions left unanswered in the forms the answers of a contact belong to
+func checkRequired(db *gorm.DB, groupID uint, formdatas []Formdata, errs map[string]string) error {
+	var (
+		ids      []uint
+		formIDs  []uint
+		answered = make(map[uint]bool)
+		required []FormQuestion
+	)
+
+	for _, f := range formdatas {
+		ids = append(ids, f.FormID)
+		answered[f.FormID] = true
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if err := db.Model(&FormQuestion{}).Where("group_id = ? AND id IN (?)", groupID, ids).Pluck("DISTINCT form_id", &formIDs).Error; err != nil {
+		return err
+	}
+	if len(formIDs) == 0 {
+		return nil
+	}
+
+	if err := db.Where("form_id IN (?) AND required = ? AND archived = ?", formIDs, true, false).Find(&required).Error; err != nil {
+		return err
+	}
+	for _, q := range required {
+		if !answered[q.ID] {
+			errs[fmt.Sprintf("forms.%d.questions.%d", q.FormID, q.ID)] = "is required"
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,19 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "github.com/jinzhu/gorm"
+
+// FormDS implements the FormSQL methods
+type FormDS interface {
+	Save(*Form, FormArgs) error
+	Delete(*Form, FormArgs) error
+	First(FormArgs) (*Form, error)
+	Find(FormArgs) ([]Form, error)
+	Revisions(FormArgs) ([]FormRevision, error)
+	QuestionTypes(FormArgs, []uint) (map[uint]string, error)
+}
+
+// FormStore returns a FormDS implementing CRUD methods for the forms and containing a gorm client
+func FormStore(db *gorm.DB) FormDS {
+	return &FormSQL{DB: db}
+}
@@ -3,16 +3,36 @@ package models
 
 import "time"
 
-// Formdata represents the components of a note
+// Operations on the formdatas of a contact, given in Formdata.Op
+const (
+	FormdataAdd    = "add"
+	FormdataUpdate = "update"
+	FormdataRemove = "remove"
+)
+
+// Formdata represents the components of a note.
+// Data holds the answer as entered, and the typed value matching the type of the question is filled from it:
+// Text for TEXT, Number for RANGE, DateValue for DATE; the choice of RADIO and CHECKBOX answers is Form_ref_id,
+// a CHECKBOX answer being one formdata per choice.
 type Formdata struct {
 	ID   uint       `db:"id" json:"id"`
 	Data string     `db:"data" json:"data"`
 	Date *time.Time `db:"date" json:"date"`
 
+	Text      string     `db:"text" json:"text,omitempty"`
+	Number    *float64   `db:"number" json:"number,omitempty"`
+	DateValue *time.Time `db:"date_value" json:"date_value,omitempty"`
+
 	GroupID     uint `db:"group_id" json:"group_id"`
 	ContactID   uint `db:"contact_id" json:"contact_id"`
 	FormID      uint `db:"form_id" json:"form_id"`
 	Form_ref_id uint `db:"form_ref_id" json:"form_ref_id"`
+
+	Version   uint       `sql:"not null;default:1" db:"version" json:"version"` // to be sent back on update, see ConflictError
+	DeletedAt *time.Time `sql:"index" db:"deleted_at" json:"deleted_at,omitempty"`
+
+	// Op is the operation on the formdata when saving a contact, FormdataAdd without ID and FormdataUpdate with one by default
+	Op string `sql:"-" db:"-" json:"op,omitempty"`
 }
 
 // FormdataArgs is used in the RPC communications between the gateway and Contacts
@@ -20,6 +40,7 @@ type FormdataArgs struct {
 	GroupID   uint
 	ContactID uint
 	FormID    uint
+	UserID    uint
 	Formdata  *Formdata
 }
 
@@ -3,6 +3,7 @@ package models
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/jinzhu/gorm"
 )
@@ -19,13 +20,59 @@ func (s *FormdataSQL) Save(n *Formdata, args FormdataArgs) error {
 	}
 
 	n.GroupID = args.Formdata.GroupID
+	answers := []Formdata{*n}
+	errs, err := checkFormdatas(s.DB, n.GroupID, answers)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	*n = answers[0]
+
 	if n.ID == 0 {
-		err := s.DB.Create(n).Error
-		s.DB.Last(n)
+		n.Version = 1
+		tx := s.DB.Begin()
+		if err = tx.Create(n).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = touchContacts(tx, n.GroupID, n.ContactID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = recordAudit(tx, n.auditEntry(AuditCreate, args), nil, n); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit().Error
+	}
+
+	before, err := s.First(FormdataArgs{Formdata: &Formdata{ID: n.ID, GroupID: args.Formdata.GroupID}})
+	if err != nil {
 		return err
 	}
 
-	return s.DB.Where("group_id = ?", args.Formdata.GroupID).Save(n).Error
+	tx := s.DB.Begin()
+	if err = bumpVersion(tx, &Formdata{}, "formdata", args.Formdata.GroupID, n.ID, n.Version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	n.Version++
+	if err = tx.Where("group_id = ?", args.Formdata.GroupID).Save(n).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = touchContacts(tx, n.GroupID, before.ContactID, n.ContactID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = recordAudit(tx, n.auditEntry(AuditUpdate, args), before, n); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
 }
 
 // Delete removes a formdata from the database
@@ -34,7 +81,25 @@ func (s *FormdataSQL) Delete(n *Formdata, args FormdataArgs) error {
 		return errors.New("delete: formdata is nil")
 	}
 
-	return s.DB.Where("group_id = ?", args.Formdata.GroupID).Delete(n).Error
+	before, err := s.First(FormdataArgs{Formdata: &Formdata{ID: n.ID, GroupID: args.Formdata.GroupID}})
+	if err != nil || before == nil {
+		return err
+	}
+	tx := s.DB.Begin()
+	if err = tx.Where("group_id = ?", args.Formdata.GroupID).Delete(before).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = touchContacts(tx, before.GroupID, before.ContactID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = recordAudit(tx, before.auditEntry(AuditDelete, args), before, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
 }
 
 // DeleteAll removes formdata from the database for a dedicated form
@@ -43,7 +108,60 @@ func (s *FormdataSQL) DeleteAll(n *Formdata, args FormdataArgs) error {
 		return errors.New("delete: formdata is nil")
 	}
 
-	return s.DB.Where("group_id = ?", args.Formdata.GroupID).Where("contact_id = ?", args.Formdata.ContactID).Where("form_id = ?", args.Formdata.FormID).Delete(n).Error
+	var (
+		formdatas []Formdata
+		tx        = s.DB.Begin()
+	)
+
+	db := tx.Where("group_id = ?", args.Formdata.GroupID).Where("contact_id = ?", args.Formdata.ContactID).Where("form_id = ?", args.Formdata.FormID)
+	if err := db.Find(&formdatas).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := db.Delete(n).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if len(formdatas) > 0 {
+		if err := touchContacts(tx, args.Formdata.GroupID, args.Formdata.ContactID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for i := range formdatas {
+		if err := recordAudit(tx, formdatas[i].auditEntry(AuditDelete, args), &formdatas[i], nil); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+// op returns the operation on the formdata, FormdataAdd or FormdataUpdate depending on its ID by default
+func (n *Formdata) op() string {
+	if n.Op != "" {
+		return n.Op
+	}
+	if n.ID == 0 {
+		return FormdataAdd
+	}
+	return FormdataUpdate
+}
+
+// sameAnswer tells whether two formdatas hold the same answer
+func (n *Formdata) sameAnswer(o *Formdata) bool {
+	if n.Data != o.Data || n.FormID != o.FormID || n.Form_ref_id != o.Form_ref_id || (n.Date == nil) != (o.Date == nil) {
+		return false
+	}
+
+	return n.Date == nil || n.Date.Equal(*o.Date)
+}
+
+// auditEntry returns the audit log entry of a change made to the formdata
+func (n *Formdata) auditEntry(action string, args FormdataArgs) AuditEntry {
+	return AuditEntry{GroupID: n.GroupID, Entity: "formdata", EntityID: n.ID, ContactID: n.ContactID, Action: action, UserID: args.UserID}
 }
 
 // First returns a formdata from the database usin it's ID
@@ -71,3 +189,58 @@ func (s *FormdataSQL) Find(args FormdataArgs) ([]Formdata, error) {
 
 	return formdatas, nil
 }
+
+// MigrateFormdatas fills the typed values of the formdatas saved before they existed, and removes the copies
+// left by the contact updates which used to insert every answer again, the latest of the copies being kept
+func MigrateFormdatas(db *gorm.DB) error {
+	var (
+		formdatas  []Formdata
+		questions  []FormQuestion
+		byID       = make(map[uint]*FormQuestion)
+		seen       = make(map[string]bool)
+		duplicates []uint
+	)
+
+	if err := db.Order("id desc").Find(&formdatas).Error; err != nil {
+		return err
+	}
+	if err := db.Find(&questions).Error; err != nil {
+		return err
+	}
+	for i := range questions {
+		byID[questions[i].ID] = &questions[i]
+	}
+
+	for i := range formdatas {
+		n := &formdatas[i]
+
+		key := fmt.Sprintf("%d/%d/%d/%s", n.ContactID, n.FormID, n.Form_ref_id, n.Data)
+		if seen[key] {
+			duplicates = append(duplicates, n.ID)
+			continue
+		}
+		seen[key] = true
+
+		if n.Text != "" || n.Number != nil || n.DateValue != nil {
+			continue
+		}
+		q := byID[n.FormID]
+		if q != nil && q.GroupID != n.GroupID {
+			q = nil
+		}
+		n.setValue(q)
+		if n.Text == "" && n.Number == nil && n.DateValue == nil {
+			continue
+		}
+		values := map[string]interface{}{"text": n.Text, "number": n.Number, "date_value": n.DateValue}
+		if err := db.Model(&Formdata{}).Where("id = ?", n.ID).UpdateColumns(values).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(duplicates) > 0 {
+		return db.Where("id IN (?)", duplicates).Delete(&Formdata{}).Error
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// SubjectAccess gathers everything held about a contact, as handed over on a GDPR subject access request
+type SubjectAccess struct {
+	Date         time.Time    `json:"date"`
+	Contact      *Contact     `json:"contact"`
+	Notes        []Note       `json:"notes"`
+	Formdatas    []Formdata   `json:"formdatas"`
+	Tags         []Tag        `json:"tags"`
+	Facts        []Fact       `json:"facts"`
+	Missions     []Mission    `json:"missions"`
+	Consents     []Consent    `json:"consents"`
+	AuditEntries []AuditEntry `json:"audit_entries"`
+}
+
+// Erasure records that the data of a contact was erased on request, it holds no personal data
+type Erasure struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	GroupID   uint      `sql:"not null;index" json:"group_id"`
+	ContactID uint      `sql:"not null;index" json:"contact_id"`
+	UserID    uint      `json:"user_id,omitempty"` // who carried it out
+	Date      time.Time `json:"date"`
+	Reason    string    `json:"reason,omitempty"`
+	Removed   string    `sql:"type:text" json:"removed"` // as {"notes": 2, ...}, the facts being kept anonymized and without location
+}
+
+// GDPRArgs is used in the RPC communications between the gateway and Contacts
+type GDPRArgs struct {
+	GroupID   uint
+	ContactID uint
+	UserID    uint
+	Reason    string
+}
+
+// GDPRReply is used in the RPC communications between the gateway and Contacts
+type GDPRReply struct {
+	SubjectAccess *SubjectAccess
+	Data          []byte // the subject access as a ZIP archive
+	Erasure       *Erasure
+	Remaining     map[string]int // by table or index, what is still held about an erased contact
+}
+
+// Zip returns the subject access as a ZIP archive, with a JSON file by kind of data and the contact as a vCard
+func (a *SubjectAccess) Zip() ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		w   = zip.NewWriter(&buf)
+	)
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"contact.json", a.Contact},
+		{"notes.json", a.Notes},
+		{"formdatas.json", a.Formdatas},
+		{"tags.json", a.Tags},
+		{"facts.json", a.Facts},
+		{"missions.json", a.Missions},
+		{"consents.json", a.Consents},
+		{"history.json", a.AuditEntries},
+	}
+
+	for _, file := range files {
+		data, err := json.MarshalIndent(file.data, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		f, err := w.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: a.Date})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = f.Write(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if a.Contact != nil {
+		f, err := w.CreateHeader(&zip.FileHeader{Name: "contact.vcf", Method: zip.Deflate, Modified: a.Date})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = f.Write([]byte(a.Contact.VCard())); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
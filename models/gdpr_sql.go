@@ -0,0 +1,184 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// GDPRSQL contains a Gorm client and the subject access and erasure related methods
+type GDPRSQL struct {
+	DB *gorm.DB
+}
+
+// Access gathers everything held about a contact, trashed items included
+func (s *GDPRSQL) Access(args GDPRArgs) (*SubjectAccess, error) {
+	var (
+		a  = SubjectAccess{Date: time.Now(), Contact: &Contact{}}
+		db = s.DB.Unscoped()
+		id = args.ContactID
+	)
+
+	if err := db.Where("group_id = ? AND id = ?", args.GroupID, id).Preload("Address").Preload("Methods").Preload("Addresses.Address").First(a.Contact).Error; err != nil {
+		if db.Where("group_id = ? AND id = ?", args.GroupID, id).First(a.Contact).RecordNotFound() {
+			return nil, errors.New("access: contact not found")
+		}
+		return nil, err
+	}
+
+	var err error
+	if a.Contact.Consents, err = consentsOf(s.DB, a.Contact); err != nil {
+		return nil, err
+	}
+
+	queries := []func() error{
+		func() error { return db.Where("contact_id = ?", id).Find(&a.Notes).Error },
+		func() error { return db.Where("contact_id = ?", id).Find(&a.Formdatas).Error },
+		func() error {
+			return db.Where("id IN (SELECT tag_id FROM contact_tags WHERE contact_id = ?)", id).Find(&a.Tags).Error
+		},
+		func() error {
+			if err := db.Where("contact_id = ?", id).Find(&a.Facts).Error; err != nil {
+				return err
+			}
+			// the location of each fact is given as in the facts index, see IndexFact
+			for i := range a.Facts {
+				if f := &a.Facts[i]; f.Latitude != nil && f.Longitude != nil {
+					f.Location = fmt.Sprintf("%f,%f", *f.Latitude, *f.Longitude)
+				}
+			}
+			return nil
+		},
+		func() error {
+			return db.Where("id IN (SELECT mission_id FROM mission_contacts WHERE contact_id = ?)", id).Find(&a.Missions).Error
+		},
+		func() error {
+			return db.Where("group_id = ? AND contact_id = ?", args.GroupID, id).Order("date desc, id desc").Find(&a.Consents).Error
+		},
+		func() error {
+			return db.Where("group_id = ? AND contact_id = ?", args.GroupID, id).Order("date desc, id desc").Find(&a.AuditEntries).Error
+		},
+	}
+	for _, q := range queries {
+		if err = q(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &a, nil
+}
+
+// Erase deletes everything held about a contact, trashed or not, and records the erasure.
+// The facts are kept for the campaign statistics but no longer point to the contact, nor to where it lives.
+func (s *GDPRSQL) Erase(args GDPRArgs) (*Erasure, error) {
+	var (
+		c          Contact
+		addressIDs []uint
+		removed    = make(map[string]int64)
+		id         = args.ContactID
+		tx         = s.DB.Begin()
+		db         = tx.Unscoped()
+	)
+
+	if err := db.Where("group_id = ? AND id = ?", args.GroupID, id).First(&c).Error; err != nil {
+		tx.Rollback()
+		if s.DB.Unscoped().Where("group_id = ? AND id = ?", args.GroupID, id).First(&c).RecordNotFound() {
+			return nil, errors.New("erase: contact not found")
+		}
+		return nil, err
+	}
+
+	if err := db.Model(&ContactAddress{}).Where("contact_id = ?", id).Pluck("address_id", &addressIDs).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	addressIDs = append(addressIDs, c.AddressID)
+
+	steps := []struct {
+		name string
+		run  func() *gorm.DB
+	}{
+		{"notes", func() *gorm.DB { return db.Where("contact_id = ?", id).Delete(&Note{}) }},
+		{"formdatas", func() *gorm.DB { return db.Where("contact_id = ?", id).Delete(&Formdata{}) }},
+		{"contact_methods", func() *gorm.DB { return db.Where("contact_id = ?", id).Delete(&ContactMethod{}) }},
+		{"contact_addresses", func() *gorm.DB { return db.Where("contact_id = ?", id).Delete(&ContactAddress{}) }},
+		{"addresses", func() *gorm.DB { return db.Where("id IN (?)", addressIDs).Delete(&Address{}) }},
+		{"consents", func() *gorm.DB { return db.Where("contact_id = ?", id).Delete(&Consent{}) }},
+		{"leases", func() *gorm.DB { return db.Where("contact_id = ?", id).Delete(&Lease{}) }},
+		{"audit_entries", func() *gorm.DB { return db.Where("contact_id = ?", id).Delete(&AuditEntry{}) }},
+		{"contact_tags", func() *gorm.DB { return tx.Exec("DELETE FROM contact_tags WHERE contact_id = ?", id) }},
+		{"mission_contacts", func() *gorm.DB { return tx.Exec("DELETE FROM mission_contacts WHERE contact_id = ?", id) }},
+		{"facts_anonymized", func() *gorm.DB {
+			// where a fact was recorded, and how far from the contact, would still point to them
+			values := map[string]interface{}{"contact_id": 0, "latitude": nil, "longitude": nil, "distance": nil}
+			return db.Model(&Fact{}).Where("contact_id = ?", id).UpdateColumns(values)
+		}},
+		{"bulk_items_anonymized", func() *gorm.DB {
+			// the jobs keep their counts, the change recorded for rolling back no longer applies
+			return db.Model(&BulkItem{}).Where("contact_id = ?", id).UpdateColumns(map[string]interface{}{"contact_id": 0, "change": ""})
+		}},
+		{"contacts", func() *gorm.DB { return db.Where("id = ?", id).Delete(&Contact{}) }},
+	}
+	for _, step := range steps {
+		res := step.run()
+		if res.Error != nil {
+			tx.Rollback()
+			return nil, res.Error
+		}
+		removed[step.name] = res.RowsAffected
+	}
+
+	data, err := json.Marshal(removed)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	e := Erasure{GroupID: args.GroupID, ContactID: id, UserID: args.UserID, Date: time.Now(), Reason: args.Reason, Removed: string(data)}
+	if err = tx.Create(&e).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return &e, tx.Commit().Error
+}
+
+// Remaining counts by table the rows still pointing to a contact, all of them are 0 once it is erased
+func (s *GDPRSQL) Remaining(args GDPRArgs) (map[string]int, error) {
+	var (
+		remaining = make(map[string]int)
+		db        = s.DB.Unscoped()
+		id        = args.ContactID
+	)
+
+	counts := []struct {
+		name  string
+		query *gorm.DB
+	}{
+		{"contacts", db.Model(&Contact{}).Where("id = ?", id)},
+		{"notes", db.Model(&Note{}).Where("contact_id = ?", id)},
+		{"formdatas", db.Model(&Formdata{}).Where("contact_id = ?", id)},
+		{"contact_methods", db.Model(&ContactMethod{}).Where("contact_id = ?", id)},
+		{"contact_addresses", db.Model(&ContactAddress{}).Where("contact_id = ?", id)},
+		{"consents", db.Model(&Consent{}).Where("contact_id = ?", id)},
+		{"leases", db.Model(&Lease{}).Where("contact_id = ?", id)},
+		{"audit_entries", db.Model(&AuditEntry{}).Where("contact_id = ?", id)},
+		{"facts", db.Model(&Fact{}).Where("contact_id = ?", id)},
+		{"contact_tags", db.Table("contact_tags").Where("contact_id = ?", id)},
+		{"mission_contacts", db.Table("mission_contacts").Where("contact_id = ?", id)},
+		{"bulk_items", db.Model(&BulkItem{}).Where("contact_id = ?", id)},
+	}
+	for _, c := range counts {
+		var n int
+		if err := c.query.Count(&n).Error; err != nil {
+			return nil, err
+		}
+		remaining[c.name] = n
+	}
+
+	return remaining, nil
+}
@@ -0,0 +1,16 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "github.com/jinzhu/gorm"
+
+// GDPRDS implements the GDPRSQL methods
+type GDPRDS interface {
+	Access(GDPRArgs) (*SubjectAccess, error)
+	Erase(GDPRArgs) (*Erasure, error)
+	Remaining(GDPRArgs) (map[string]int, error)
+}
+
+// GDPRStore returns a GDPRDS implementing the subject access and erasure methods and containing a gorm client
+func GDPRStore(db *gorm.DB) GDPRDS {
+	return &GDPRSQL{DB: db}
+}
@@ -0,0 +1,46 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "time"
+
+// GeofenceReport represents the facts recorded by the volunteers, and how many were recorded too far from the contacts
+type GeofenceReport struct {
+	Volunteers []GeofenceVolunteer `json:"volunteers"` // the most suspicious first
+}
+
+// GeofenceVolunteer represents the facts recorded by a volunteer, by period
+type GeofenceVolunteer struct {
+	UserID     uint             `json:"user_id"`
+	Facts      int              `json:"facts"`
+	Located    int              `json:"located"` // facts whose distance to the contact is known
+	Suspicious int              `json:"suspicious"`
+	Rate       float64          `json:"rate"` // share of the located facts which are suspicious
+	Periods    []GeofencePeriod `json:"periods"`
+	FactIDs    []uint           `json:"fact_ids,omitempty"` // the suspicious facts
+}
+
+// GeofencePeriod represents the facts recorded by a volunteer during a period starting at Date
+type GeofencePeriod struct {
+	Date       time.Time `json:"date"`
+	Facts      int       `json:"facts"`
+	Located    int       `json:"located"`
+	Suspicious int       `json:"suspicious"`
+}
+
+// periodStart returns the start of the period of the interval, one of FactIntervals, containing t
+func periodStart(t time.Time, interval string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch interval {
+	case "hour":
+		return t.Truncate(time.Hour)
+	case "week":
+		// the weeks start on monday
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case "month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	return day
+}
@@ -0,0 +1,28 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+// DefaultTrashRetention is the number of days trashed items are kept when the group didn't set its own
+const DefaultTrashRetention = 30
+
+// DefaultGeofenceRadius is the distance in meters from the address of a contact beyond which a fact is suspicious,
+// when the group didn't set its own
+const DefaultGeofenceRadius = 150
+
+// GroupSetting represents the per group configuration of the contacts backend
+type GroupSetting struct {
+	GroupID          uint `gorm:"primary_key" json:"group_id"`
+	TrashRetention   uint `json:"trash_retention"`   // in days, DefaultTrashRetention when 0
+	StrictValidation bool `json:"strict_validation"` // invalid contacts are refused instead of saved as entered
+	GeofenceRadius   uint `json:"geofence_radius"`   // in meters, DefaultGeofenceRadius when 0, see Fact.Suspicious
+}
+
+// GroupSettingArgs is used in the RPC communications between the gateway and Contacts
+type GroupSettingArgs struct {
+	GroupSetting *GroupSetting
+	Fields       []string // the JSON names of the settings sent by the client, the others being left as they are; all of them when empty
+}
+
+// GroupSettingReply is used in the RPC communications between the gateway and Contacts
+type GroupSettingReply struct {
+	GroupSetting *GroupSetting
+}
@@ -0,0 +1,85 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// GroupSettingSQL contains a Gorm client and the group setting and gorm related methods
+type GroupSettingSQL struct {
+	DB *gorm.DB
+}
+
+// Save inserts or updates the settings of a group into the database. When args.Fields is given, only the settings it names
+// are updated and the group setting is filled back with the settings as stored.
+func (s *GroupSettingSQL) Save(gs *GroupSetting, args GroupSettingArgs) error {
+	if gs == nil {
+		return errors.New("save: group setting is nil")
+	}
+
+	gs.GroupID = args.GroupSetting.GroupID
+	if gs.GroupID == 0 {
+		return errors.New("save: group setting has no group")
+	}
+
+	if len(args.Fields) == 0 {
+		var existing GroupSetting
+		if s.DB.Where("group_id = ?", gs.GroupID).First(&existing).RecordNotFound() {
+			return s.DB.Create(gs).Error
+		}
+		return s.DB.Save(gs).Error
+	}
+
+	changes := make(map[string]interface{})
+	for _, field := range args.Fields {
+		switch field {
+		case "trash_retention":
+			changes[field] = gs.TrashRetention
+		case "strict_validation":
+			changes[field] = gs.StrictValidation
+		case "geofence_radius":
+			changes[field] = gs.GeofenceRadius
+		default:
+			return fmt.Errorf("save: unknown group setting %q", field)
+		}
+	}
+
+	var err error
+	tx := s.DB.Begin()
+	var current GroupSetting
+	if tx.Where("group_id = ?", gs.GroupID).First(&current).RecordNotFound() {
+		// the settings not sent keep their defaults
+		current = GroupSetting{GroupID: gs.GroupID}
+		if err = tx.Create(&current).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = tx.Model(&current).UpdateColumns(changes).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Where("group_id = ?", gs.GroupID).First(gs).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// First returns the settings of a group from the database, or the defaults if the group has none
+func (s *GroupSettingSQL) First(args GroupSettingArgs) (*GroupSetting, error) {
+	var gs GroupSetting
+
+	if err := s.DB.Where("group_id = ?", args.GroupSetting.GroupID).First(&gs).Error; err != nil {
+		if s.DB.Where("group_id = ?", args.GroupSetting.GroupID).First(&gs).RecordNotFound() {
+			return &GroupSetting{GroupID: args.GroupSetting.GroupID, TrashRetention: DefaultTrashRetention, GeofenceRadius: DefaultGeofenceRadius}, nil
+		}
+		return nil, err
+	}
+
+	return &gs, nil
+}
@@ -0,0 +1,15 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "github.com/jinzhu/gorm"
+
+// GroupSettingDS implements the GroupSettingSQL methods
+type GroupSettingDS interface {
+	Save(*GroupSetting, GroupSettingArgs) error
+	First(GroupSettingArgs) (*GroupSetting, error)
+}
+
+// GroupSettingStore returns a GroupSettingDS implementing CRUD methods for the group settings and containing a gorm client
+func GroupSettingStore(db *gorm.DB) GroupSettingDS {
+	return &GroupSettingSQL{DB: db}
+}
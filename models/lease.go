@@ -0,0 +1,44 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "time"
+
+// Durations of the leases
+const (
+	LeaseDuration    = 15 * time.Minute // when the claim gives none
+	MaxLeaseDuration = time.Hour
+)
+
+// Lease represents a building claimed by a volunteer while visiting it, so that nobody else knocks at the same doors.
+// It ends when it expires, when it is released or when a fact is recorded on a contact of the building.
+type Lease struct {
+	ID        uint       `gorm:"primary_key" json:"id"`
+	GroupID   uint       `sql:"not null;index;unique_index:uix_leases_covers" json:"group_id"`
+	ContactID uint       `sql:"index" json:"contact_id"`         // the contact claimed
+	Building  string     `sql:"index" json:"building,omitempty"` // the building of the contact, all its contacts being covered
+	UserID    uint       `json:"user_id"`
+	MissionID uint       `json:"mission_id,omitempty"`
+	Claimed   time.Time  `json:"claimed"`
+	Expires   time.Time  `json:"expires"`
+	Released  *time.Time `json:"released,omitempty"`
+
+	// the building, or the contact when it has none, while the lease runs. Being unique in the group,
+	// two volunteers claiming the same building at once can't both get it.
+	Covers *string `sql:"unique_index:uix_leases_covers" json:"-"`
+}
+
+// LeaseArgs is used in the RPC communications between the gateway and Contacts
+type LeaseArgs struct {
+	GroupID   uint
+	UserID    uint
+	ContactID uint
+	MissionID uint
+	LeaseID   uint
+	Duration  time.Duration // of the claim, LeaseDuration by default
+}
+
+// LeaseReply is used in the RPC communications between the gateway and Contacts
+type LeaseReply struct {
+	Lease  *Lease
+	Leases []Lease
+}
@@ -0,0 +1,184 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// LeaseSQL contains a Gorm client and the lease and gorm related methods
+type LeaseSQL struct {
+	DB *gorm.DB
+}
+
+// Claim gives the building of a contact to a volunteer for a while. A volunteer claiming again a building
+// extends the lease, a building claimed by another volunteer is refused until the lease ends.
+func (s *LeaseSQL) Claim(args LeaseArgs) (*Lease, error) {
+	var c Contact
+
+	if err := s.DB.Where("group_id = ? AND id = ?", args.GroupID, args.ContactID).Preload("Address").First(&c).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.GroupID, args.ContactID).First(&c).RecordNotFound() {
+			return nil, errors.New("claim: contact not found")
+		}
+		return nil, err
+	}
+
+	duration := args.Duration
+	if duration <= 0 {
+		duration = LeaseDuration
+	}
+	if duration > MaxLeaseDuration {
+		duration = MaxLeaseDuration
+	}
+
+	now := time.Now()
+	l := Lease{GroupID: args.GroupID, ContactID: c.ID, Building: buildingKey(c.Address), UserID: args.UserID, MissionID: args.MissionID, Claimed: now, Expires: now.Add(duration)}
+	covers := l.Building
+	if covers == "" {
+		covers = fmt.Sprintf("contact:%d", c.ID)
+	}
+	l.Covers = &covers
+
+	tx := s.DB.Begin()
+	// an expired lease no longer holds the building
+	if err := tx.Model(&Lease{}).Where("group_id = ? AND covers = ? AND expires <= ?", args.GroupID, covers, now).UpdateColumn("covers", nil).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	current, err := running(tx, args.GroupID, l.ContactID, l.Building, args.UserID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if current != nil {
+		l = *current
+		l.Expires = now.Add(duration)
+		if err = tx.Model(&l).UpdateColumn("expires", l.Expires).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	} else if err = tx.Create(&l).Error; err != nil {
+		tx.Rollback()
+		// the building was claimed at the same time, the unique index on Covers refused the second lease
+		if current, cerr := running(s.DB, args.GroupID, l.ContactID, l.Building, args.UserID); cerr != nil || current != nil {
+			return current, cerr
+		}
+		return nil, err
+	}
+
+	return &l, tx.Commit().Error
+}
+
+// running returns the running lease of the volunteer on a contact or on its building,
+// or an error when another volunteer holds it
+func running(db *gorm.DB, groupID uint, contactID uint, building string, userID uint) (*Lease, error) {
+	var current []Lease
+
+	if err := covering(db, groupID, contactID, building).Find(&current).Error; err != nil {
+		return nil, err
+	}
+	for _, other := range current {
+		if other.UserID != userID {
+			return nil, fmt.Errorf("claim: building is being visited by user %d until %s", other.UserID, other.Expires.Format(time.RFC3339))
+		}
+	}
+	if len(current) == 0 {
+		return nil, nil
+	}
+
+	return &current[0], nil
+}
+
+// Release ends a lease of the volunteer, given by its ID or by the contact claimed
+func (s *LeaseSQL) Release(args LeaseArgs) error {
+	db := s.DB.Model(&Lease{}).Where("group_id = ? AND user_id = ? AND released IS NULL", args.GroupID, args.UserID)
+	if args.LeaseID != 0 {
+		db = db.Where("id = ?", args.LeaseID)
+	} else if args.ContactID != 0 {
+		db = db.Where("contact_id = ?", args.ContactID)
+	} else {
+		return errors.New("release: no lease given")
+	}
+
+	return db.UpdateColumns(map[string]interface{}{"released": time.Now(), "covers": nil}).Error
+}
+
+// Find returns the leases of a group which are still running
+func (s *LeaseSQL) Find(args LeaseArgs) ([]Lease, error) {
+	var leases []Lease
+
+	err := s.DB.Where("group_id = ? AND released IS NULL AND expires > ?", args.GroupID, time.Now()).Order("claimed").Find(&leases).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return leases, nil
+}
+
+// Flag sets on the contacts the running lease of their building, if any
+func (s *LeaseSQL) Flag(groupID uint, contacts []Contact) error {
+	var (
+		ids       []uint
+		buildings []string
+		leases    []Lease
+	)
+
+	for _, c := range contacts {
+		ids = append(ids, c.ID)
+		if key := buildingKey(c.Address); key != "" {
+			buildings = append(buildings, key)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	db := s.DB.Where("group_id = ? AND released IS NULL AND expires > ?", groupID, time.Now())
+	if len(buildings) > 0 {
+		db = db.Where("contact_id IN (?) OR building IN (?)", ids, buildings)
+	} else {
+		db = db.Where("contact_id IN (?)", ids)
+	}
+	if err := db.Find(&leases).Error; err != nil {
+		return err
+	}
+
+	for i := range contacts {
+		key := buildingKey(contacts[i].Address)
+		for j := range leases {
+			if leases[j].ContactID == contacts[i].ID || (key != "" && leases[j].Building == key) {
+				contacts[i].Lease = &leases[j]
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// covering returns the running leases on a contact or on its building
+func covering(db *gorm.DB, groupID uint, contactID uint, building string) *gorm.DB {
+	db = db.Where("group_id = ? AND released IS NULL AND expires > ?", groupID, time.Now())
+	if building == "" {
+		return db.Where("contact_id = ?", contactID)
+	}
+	return db.Where("contact_id = ? OR building = ?", contactID, building)
+}
+
+// releaseVisited ends the leases on the building of a contact once a fact is recorded on it
+func releaseVisited(db *gorm.DB, groupID uint, contactID uint) error {
+	var c Contact
+
+	if err := db.Where("group_id = ? AND id = ?", groupID, contactID).Preload("Address").First(&c).Error; err != nil {
+		if db.Where("group_id = ? AND id = ?", groupID, contactID).First(&c).RecordNotFound() {
+			return nil
+		}
+		return err
+	}
+
+	return covering(db.Model(&Lease{}), groupID, c.ID, buildingKey(c.Address)).UpdateColumns(map[string]interface{}{"released": time.Now(), "covers": nil}).Error
+}
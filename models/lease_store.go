@@ -0,0 +1,17 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "github.com/jinzhu/gorm"
+
+// LeaseDS implements the LeaseSQL methods
+type LeaseDS interface {
+	Claim(LeaseArgs) (*Lease, error)
+	Release(LeaseArgs) error
+	Find(LeaseArgs) ([]Lease, error)
+	Flag(uint, []Contact) error
+}
+
+// LeaseStore returns a LeaseDS implementing the lease methods and containing a gorm client
+func LeaseStore(db *gorm.DB) LeaseDS {
+	return &LeaseSQL{DB: db}
+}
@@ -3,23 +3,96 @@ package models
 
 import "time"
 
+// States of the missions
+const (
+	MissionDraft     = "draft"
+	MissionActive    = "active"
+	MissionDone      = "done"
+	MissionCancelled = "cancelled"
+)
+
+// MissionStatuses lists the states of the missions
+var MissionStatuses = []string{MissionDraft, MissionActive, MissionDone, MissionCancelled}
+
 // Mission represents the components of a Mission
 type Mission struct {
-	ID   uint       `gorm:"primary_key" json:"id"`
-	Date *time.Time `json:"date,omitempty"`
+	ID          uint       `gorm:"primary_key" json:"id"`
+	Name        string     `json:"name,omitempty"`
+	Description string     `sql:"type:text" json:"description,omitempty"`
+	Status      string     `json:"status,omitempty"` // one of MissionStatuses, draft by default
+	Date        *time.Time `json:"date,omitempty"`
+	Start       *time.Time `json:"start,omitempty"`
+	End         *time.Time `json:"end,omitempty"`
+
+	GroupID  uint `sql:"not null" db:"group_id" json:"-"`
+	ParentID uint `sql:"index" json:"parent_id,omitempty"` // the mission whose territory was partitioned into this one
+
+	Assignees []MissionAssignee `json:"assignees,omitempty"`
 
-	GroupID uint `sql:"not null" db:"group_id" json:"-"`
+	// the contacts of the mission are the ones inside the territory matching the search, see Mission.Populate
+	Polygon   []Point `sql:"-" json:"territory,omitempty"`
+	Search    *Search `sql:"-" json:"search,omitempty"`
+	Territory string  `sql:"type:text" json:"-"` // Polygon as JSON
+	Filter    string  `sql:"type:text" json:"-"` // Search as JSON
 
 	Contacts []Contact `json:"contacts,omitempty" gorm:"many2many:mission_contacts;"`
+
+	DeletedAt *time.Time `sql:"index" json:"deleted_at,omitempty"`
+}
+
+// MissionAssignee represents a user or a team of users assigned to a mission
+type MissionAssignee struct {
+	ID        uint `gorm:"primary_key" json:"id"`
+	MissionID uint `sql:"not null;index" json:"mission_id"`
+	UserID    uint `json:"user_id,omitempty"`
+	TeamID    uint `json:"team_id,omitempty"`
+}
+
+// MissionProgress represents how far the contacts of a mission were visited, from the facts and the answers
+// recorded on them during the mission
+type MissionProgress struct {
+	MissionID  uint                `json:"mission_id"`
+	Contacts   int                 `json:"contacts"`
+	Visited    int                 `json:"visited"`  // contacts having a fact or an answer
+	Answered   int                 `json:"answered"` // contacts having an answer
+	Completion float64             `json:"completion"`
+	Statuses   map[string]int      `json:"statuses"` // contacts by status of their last fact
+	Volunteers []VolunteerProgress `json:"volunteers,omitempty"`
+	Timeline   []ProgressDay       `json:"timeline,omitempty"`
+	Missing    []uint              `json:"missing,omitempty"` // contacts not visited yet
+}
+
+// VolunteerProgress represents the contacts visited by a user during a mission
+type VolunteerProgress struct {
+	UserID  uint `json:"user_id"`
+	Visited int  `json:"visited"`
+	Facts   int  `json:"facts"`
+	Answers int  `json:"answers"`
+}
+
+// ProgressDay represents the work done on a day of a mission, a contact being visited on its first fact or answer
+type ProgressDay struct {
+	Date    string `json:"date"` // as 2006-01-02
+	Visited int    `json:"visited"`
+	Facts   int    `json:"facts"`
+	Answers int    `json:"answers"`
 }
 
 // MissionArgs is used in the RPC communications between the gateway and Contacts
 type MissionArgs struct {
+	UserID  uint
 	Mission *Mission
+	Start   *Point // where the walk of Route starts, at its first building by default
+	Parts   int    // number of walk lists of Partition,
+	Create  bool   // which creates a sub-mission for each list when set
 }
 
 // MissonReply is used in the RPC communications between the gateway and Contacts
 type MissionReply struct {
-	Mission  *Mission
-	Missions []Mission
+	Mission    *Mission
+	Missions   []Mission
+	ContactIDs []uint // the contacts found in the territory of the mission by Populate
+	Route      *Route
+	Partition  *Partition
+	Progress   *MissionProgress
 }
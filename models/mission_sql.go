@@ -2,61 +2,445 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 
 	"github.com/jinzhu/gorm"
 )
 
-// ContactSQL contains a Gorm client and the mission and gorm related methods
+// MissionSQL contains a Gorm client and the mission and gorm related methods
 type MissionSQL struct {
 	DB *gorm.DB
 }
 
-// Save inserts a new mission into the database
+// Save inserts a new mission into the database, or updates a mission of its group
 func (s *MissionSQL) Save(m *Mission, args MissionArgs) error {
 	if m == nil {
 		return errors.New("save: mission is nil")
 	}
 
+	if m.Status == "" {
+		m.Status = MissionDraft
+	}
+	if err := m.check(); err != nil {
+		return err
+	}
+	if err := m.encode(); err != nil {
+		return err
+	}
+	// the contacts given are added through AddContacts, scoped to the group and audited, gorm would save them as sent
+	var contactIDs []uint
+	for _, c := range m.Contacts {
+		if c.ID != 0 {
+			contactIDs = append(contactIDs, c.ID)
+		}
+	}
+	m.Contacts = nil
+
 	if m.ID == 0 {
-		return s.DB.Save(m).Error
+		tx := s.DB.Begin()
+		if err := tx.Create(m).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := recordAudit(tx, m.auditEntry(AuditCreate, args), nil, m); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+		return s.AddContacts(MissionArgs{UserID: args.UserID, Mission: m}, contactIDs)
+	}
+
+	before, err := s.First(MissionArgs{Mission: &Mission{ID: m.ID, GroupID: m.GroupID}})
+	if err != nil {
+		return err
+	}
+	if before == nil {
+		return errors.New("save: mission not found")
+	}
+
+	// the assignees are replaced by the ones given
+	tx := s.DB.Begin()
+	if err = tx.Where("mission_id = ?", m.ID).Delete(&MissionAssignee{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	for i := range m.Assignees {
+		m.Assignees[i].ID, m.Assignees[i].MissionID = 0, m.ID
+	}
+	if err = tx.Save(m).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = recordAudit(tx, m.auditEntry(AuditUpdate, args), before, m); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit().Error; err != nil {
+		return err
+	}
+
+	return s.AddContacts(MissionArgs{UserID: args.UserID, Mission: m}, contactIDs)
+}
+
+// check refuses the missions whose status, schedule, territory or assignees are invalid
+func (m *Mission) check() error {
+	if !contains(MissionStatuses, m.Status) {
+		return fmt.Errorf("save: unknown status %q", m.Status)
+	}
+	if m.Start != nil && m.End != nil && m.End.Before(*m.Start) {
+		return errors.New("save: mission ends before it starts")
+	}
+	if len(m.Polygon) > 0 && len(m.Polygon) < 3 {
+		return errors.New("save: territory needs at least 3 points")
+	}
+	for _, a := range m.Assignees {
+		if (a.UserID == 0) == (a.TeamID == 0) {
+			return errors.New("save: an assignee is either a user or a team")
+		}
 	}
 
-	return s.DB.Update(m).Error
+	return nil
 }
 
-// Delete removes a mission from the database
+// encode stores the territory and the search of the mission as JSON
+func (m *Mission) encode() error {
+	m.Territory, m.Filter = "", ""
+
+	if len(m.Polygon) > 0 {
+		data, err := json.Marshal(m.Polygon)
+		if err != nil {
+			return err
+		}
+		m.Territory = string(data)
+	}
+	if m.Search != nil {
+		data, err := json.Marshal(m.Search)
+		if err != nil {
+			return err
+		}
+		m.Filter = string(data)
+	}
+
+	return nil
+}
+
+// decode fills the territory and the search of the mission from their JSON
+func (m *Mission) decode() error {
+	m.Polygon, m.Search = nil, nil
+
+	if m.Territory != "" {
+		if err := json.Unmarshal([]byte(m.Territory), &m.Polygon); err != nil {
+			return err
+		}
+	}
+	if m.Filter != "" {
+		m.Search = &Search{}
+		if err := json.Unmarshal([]byte(m.Filter), m.Search); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a mission of its group from the database
 func (s *MissionSQL) Delete(m *Mission, args MissionArgs) error {
 	if m == nil {
-		return errors.New("save: mission is nil")
+		return errors.New("delete: mission is nil")
+	}
+
+	before, err := s.First(MissionArgs{Mission: &Mission{ID: m.ID, GroupID: m.GroupID}})
+	if err != nil {
+		return err
+	}
+	if before == nil {
+		return errors.New("delete: mission not found")
 	}
 
-	return s.DB.Delete(m).Error
+	tx := s.DB.Begin()
+	if err = tx.Where("group_id = ? AND id = ?", before.GroupID, before.ID).Delete(&Mission{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = recordAudit(tx, before.auditEntry(AuditDelete, args), before, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// auditEntry returns the audit log entry of a change made to the mission
+func (m *Mission) auditEntry(action string, args MissionArgs) AuditEntry {
+	return AuditEntry{GroupID: m.GroupID, Entity: "mission", EntityID: m.ID, Action: action, UserID: args.UserID}
 }
 
-// Find returns all the mission with a given groupID from the database
+// memberEntry returns the audit log entry of a contact added to the mission, or removed from it
+func (m *Mission) memberEntry(action string, args MissionArgs, contactID uint) AuditEntry {
+	e := m.auditEntry(action, args)
+	e.ContactID = contactID
+	return e
+}
+
+// Find returns the missions of a group from the database, restricted to a status if args.Mission.Status is set
 func (s *MissionSQL) Find(args MissionArgs) ([]Mission, error) {
 	var missions []Mission
 
-	err := s.DB.Where(args.Mission).Find(&missions).Error
+	if args.Mission == nil {
+		return nil, errors.New("find: no group given")
+	}
 
-	if err != nil {
+	db := s.DB.Where("group_id = ?", args.Mission.GroupID)
+	if args.Mission.Status != "" {
+		db = db.Where("status = ?", args.Mission.Status)
+	}
+	if err := db.Preload("Assignees").Order("id").Find(&missions).Error; err != nil {
 		return nil, err
 	}
+	for i := range missions {
+		if err := missions[i].decode(); err != nil {
+			return nil, err
+		}
+	}
 
 	return missions, nil
 }
 
-// First returns a mission from the database using it's ID
+// First returns a mission of a group from the database using it's ID
 func (s *MissionSQL) First(args MissionArgs) (*Mission, error) {
 	var m Mission
 
-	if err := s.DB.Where(args.Mission).First(&m).Error; err != nil {
-		if s.DB.Where(args.Mission).First(&m).RecordNotFound() {
+	if args.Mission == nil {
+		return nil, errors.New("first: no mission given")
+	}
+
+	if err := s.DB.Where("group_id = ? AND id = ?", args.Mission.GroupID, args.Mission.ID).Preload("Assignees").First(&m).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.Mission.GroupID, args.Mission.ID).First(&m).RecordNotFound() {
 			return nil, nil
 		}
 		return nil, err
 	}
 
-	return &m, nil
+	return &m, m.decode()
+}
+
+// ContactIDs returns the contacts of a mission of the group
+func (s *MissionSQL) ContactIDs(args MissionArgs) ([]uint, error) {
+	var ids []uint
+
+	if args.Mission == nil || args.Mission.ID == 0 {
+		return ids, nil
+	}
+
+	err := s.DB.Table("mission_contacts").
+		Joins("JOIN missions ON missions.id = mission_contacts.mission_id").
+		Where("mission_contacts.mission_id = ? AND missions.group_id = ?", args.Mission.ID, args.Mission.GroupID).
+		Pluck("mission_contacts.contact_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// AddContact adds a contact of the group to a mission, unless it is already in it
+func (s *MissionSQL) AddContact(args MissionArgs, contactID uint) error {
+	return s.AddContacts(args, []uint{contactID})
+}
+
+// AddContacts adds the contacts of the group to a mission, leaving out the ones already in it
+func (s *MissionSQL) AddContacts(args MissionArgs, contactIDs []uint) error {
+	var m Mission
+
+	if args.Mission == nil {
+		return errors.New("add: mission is nil")
+	}
+	if err := s.DB.Where("group_id = ? AND id = ?", args.Mission.GroupID, args.Mission.ID).First(&m).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.Mission.GroupID, args.Mission.ID).First(&m).RecordNotFound() {
+			return errors.New("add: mission not found")
+		}
+		return err
+	}
+
+	tx := s.DB.Begin()
+	for _, id := range contactIDs {
+		insert := "INSERT INTO mission_contacts (mission_id, contact_id) SELECT ?, id FROM contacts WHERE id = ? AND group_id = ? AND deleted_at IS NULL " +
+			"AND NOT EXISTS (SELECT 1 FROM mission_contacts WHERE mission_id = ? AND contact_id = ?)"
+		res := tx.Exec(insert, m.ID, id, m.GroupID, m.ID, id)
+		if res.Error != nil {
+			tx.Rollback()
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			continue
+		}
+		// the contact is new to the address book of the mission, see FindDeletedSince for the ones taken out
+		if err := touchContacts(tx, m.GroupID, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := recordAudit(tx, m.memberEntry(AuditCreate, args, id), nil, &m); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+// RemoveContact takes a contact out of a mission of the group
+func (s *MissionSQL) RemoveContact(args MissionArgs, contactID uint) error {
+	var m Mission
+
+	if args.Mission == nil {
+		return errors.New("remove: mission is nil")
+	}
+	if err := s.DB.Where("group_id = ? AND id = ?", args.Mission.GroupID, args.Mission.ID).First(&m).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.Mission.GroupID, args.Mission.ID).First(&m).RecordNotFound() {
+			return errors.New("remove: mission not found")
+		}
+		return err
+	}
+
+	tx := s.DB.Begin()
+	res := tx.Exec("DELETE FROM mission_contacts WHERE mission_id = ? AND contact_id = ?", m.ID, contactID)
+	if res.Error != nil {
+		tx.Rollback()
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		if err := recordAudit(tx, m.memberEntry(AuditDelete, args, contactID), &m, nil); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+// Progress computes the progress of a mission of the group from the audit log of the facts and answers
+// recorded on its contacts, since the start of the mission, or its creation, and until its end
+func (s *MissionSQL) Progress(args MissionArgs) (*MissionProgress, error) {
+	var (
+		entries []AuditEntry
+		facts   []Fact
+	)
+
+	m, err := s.First(args)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, errors.New("progress: mission not found")
+	}
+
+	ids, err := s.ContactIDs(MissionArgs{Mission: m})
+	if err != nil {
+		return nil, err
+	}
+	p := &MissionProgress{MissionID: m.ID, Contacts: len(ids), Statuses: make(map[string]int)}
+	if len(ids) == 0 {
+		return p, nil
+	}
+
+	db := s.DB.Where("group_id = ? AND contact_id IN (?) AND entity IN (?) AND action IN (?)", m.GroupID, ids, []string{"fact", "formdata"}, []string{AuditCreate, AuditUpdate})
+	if m.Start != nil {
+		db = db.Where("date >= ?", *m.Start)
+	} else {
+		var created AuditEntry
+		if err = s.DB.Where("group_id = ? AND entity = ? AND entity_id = ? AND action = ? AND contact_id = 0", m.GroupID, "mission", m.ID, AuditCreate).First(&created).Error; err == nil {
+			db = db.Where("date >= ?", created.Date)
+		}
+	}
+	if m.End != nil {
+		db = db.Where("date <= ?", *m.End)
+	}
+	if err = db.Order("date, id").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	if err = s.DB.Where("group_id = ? AND contact_id IN (?)", m.GroupID, ids).Find(&facts).Error; err != nil {
+		return nil, err
+	}
+	status := make(map[uint]string)
+	for _, f := range facts {
+		status[f.ID] = f.Status
+	}
+
+	var (
+		visited    = make(map[uint]bool)
+		answered   = make(map[uint]bool)
+		last       = make(map[uint]string)
+		volunteers = make(map[uint]*VolunteerProgress)
+		seen       = make(map[uint]map[uint]bool)
+		days       = make(map[string]*ProgressDay)
+		users      []uint
+		dates      []string
+	)
+	for _, e := range entries {
+		st, ok := status[e.EntityID]
+		if e.Entity == "fact" && !ok {
+			// the deleted facts are left out
+			continue
+		}
+
+		v := volunteers[e.UserID]
+		if v == nil {
+			v = &VolunteerProgress{UserID: e.UserID}
+			volunteers[e.UserID] = v
+			users = append(users, e.UserID)
+			seen[e.UserID] = make(map[uint]bool)
+		}
+		date := e.Date.Format("2006-01-02")
+		d := days[date]
+		if d == nil {
+			d = &ProgressDay{Date: date}
+			days[date] = d
+			dates = append(dates, date)
+		}
+
+		if e.Entity == "fact" {
+			last[e.ContactID] = st
+			v.Facts++
+			d.Facts++
+		} else {
+			answered[e.ContactID] = true
+			v.Answers++
+			d.Answers++
+		}
+
+		if !seen[e.UserID][e.ContactID] {
+			seen[e.UserID][e.ContactID] = true
+			v.Visited++
+		}
+		if !visited[e.ContactID] {
+			visited[e.ContactID] = true
+			d.Visited++
+		}
+	}
+
+	// volunteers and days are listed in the order they first appear
+	for _, id := range users {
+		p.Volunteers = append(p.Volunteers, *volunteers[id])
+	}
+	for _, date := range dates {
+		p.Timeline = append(p.Timeline, *days[date])
+	}
+
+	for _, st := range last {
+		p.Statuses[st]++
+	}
+	for _, id := range ids {
+		if !visited[id] {
+			p.Missing = append(p.Missing, id)
+		}
+	}
+	p.Visited, p.Answered = len(visited), len(answered)
+	p.Completion = float64(p.Visited) / float64(p.Contacts)
+
+	return p, nil
 }
@@ -9,6 +9,11 @@ type MissionDS interface {
 	Delete(*Mission, MissionArgs) error
 	First(MissionArgs) (*Mission, error)
 	Find(MissionArgs) ([]Mission, error)
+	ContactIDs(MissionArgs) ([]uint, error)
+	AddContact(MissionArgs, uint) error
+	AddContacts(MissionArgs, []uint) error
+	RemoveContact(MissionArgs, uint) error
+	Progress(MissionArgs) (*MissionProgress, error)
 }
 
 // Missionstore returns a MissionDS implementing CRUD methods for the missions and containing a gorm client
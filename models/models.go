@@ -10,6 +10,8 @@ import (
 // Models return one of every model the database must create..
 func Models() []interface{} {
 	return []interface{}{
-		&Contact{}, &Note{}, &Formdata{}, &Tag{}, &Mission{}, &Address{}, &Fact{}, &Action{},
+		&Contact{}, &Note{}, &Formdata{}, &Tag{}, &Mission{}, &MissionAssignee{}, &Address{}, &Fact{}, &Action{}, &ActionUser{}, &ActionTransition{},
+		&GroupSetting{}, &AuditEntry{}, &ContactMethod{}, &ContactAddress{}, &Consent{},
+		&Erasure{}, &Form{}, &FormQuestion{}, &FormChoice{}, &FormRevision{}, &BulkJob{}, &BulkItem{}, &Lease{},
 	}
 }
@@ -12,12 +12,16 @@ type Note struct {
 
 	GroupID   uint `db:"group_id" json:"group_id"`
 	ContactID uint `db:"contact_id" json:"contact_id"`
+
+	Version   uint       `sql:"not null;default:1" db:"version" json:"version"` // to be sent back on update, see ConflictError
+	DeletedAt *time.Time `sql:"index" db:"deleted_at" json:"deleted_at,omitempty"`
 }
 
 // NoteArgs is used in the RPC communications between the gateway and Contacts
 type NoteArgs struct {
 	GroupID   uint
 	ContactID uint
+	UserID    uint
 	Note      *Note
 }
 
@@ -20,12 +20,48 @@ func (s *NoteSQL) Save(n *Note, args NoteArgs) error {
 
 	n.GroupID = args.Note.GroupID
 	if n.ID == 0 {
-		err := s.DB.Create(n).Error
-		s.DB.Last(n)
+		n.Version = 1
+		tx := s.DB.Begin()
+		if err := tx.Create(n).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := touchContacts(tx, n.GroupID, n.ContactID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := recordAudit(tx, n.auditEntry(AuditCreate, args), nil, n); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit().Error
+	}
+
+	before, err := s.First(NoteArgs{Note: &Note{ID: n.ID, GroupID: args.Note.GroupID}})
+	if err != nil {
+		return err
+	}
+
+	tx := s.DB.Begin()
+	if err = bumpVersion(tx, &Note{}, "note", args.Note.GroupID, n.ID, n.Version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	n.Version++
+	if err = tx.Where("group_id = ?", args.Note.GroupID).Save(n).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = touchContacts(tx, n.GroupID, before.ContactID, n.ContactID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = recordAudit(tx, n.auditEntry(AuditUpdate, args), before, n); err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	return s.DB.Where("group_id = ?", args.Note.GroupID).Save(n).Error
+	return tx.Commit().Error
 }
 
 // Delete removes a note from the database
@@ -34,7 +70,30 @@ func (s *NoteSQL) Delete(n *Note, args NoteArgs) error {
 		return errors.New("delete: note is nil")
 	}
 
-	return s.DB.Where("group_id = ?", args.Note.GroupID).Delete(n).Error
+	before, err := s.First(NoteArgs{Note: &Note{ID: n.ID, GroupID: args.Note.GroupID}})
+	if err != nil || before == nil {
+		return err
+	}
+	tx := s.DB.Begin()
+	if err = tx.Where("group_id = ?", args.Note.GroupID).Delete(before).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = touchContacts(tx, before.GroupID, before.ContactID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = recordAudit(tx, before.auditEntry(AuditDelete, args), before, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// auditEntry returns the audit log entry of a change made to the note
+func (n *Note) auditEntry(action string, args NoteArgs) AuditEntry {
+	return AuditEntry{GroupID: n.GroupID, Entity: "note", EntityID: n.ID, ContactID: n.ContactID, Action: action, UserID: args.UserID}
 }
 
 // First returns a note from the database usin it's ID
@@ -0,0 +1,273 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// Parameters of the partition of a territory
+const (
+	PartitionSlack  = 1.1 // a walk list may hold up to 10% more doors than the average
+	PartitionPasses = 20  // passes of the balanced clustering
+)
+
+// Partition represents the territory of a mission cut into walk lists of about the same number of doors
+type Partition struct {
+	MissionID uint       `json:"mission_id"`
+	Lists     []WalkList `json:"lists"`
+	Unlocated []uint     `json:"unlocated,omitempty"` // the contacts without coordinates, left out of the lists
+}
+
+// WalkList represents a part of a territory, walked by a pair of volunteers
+type WalkList struct {
+	Rank       int     `json:"rank"`
+	MissionID  uint    `json:"mission_id,omitempty"` // the sub-mission created for the list
+	ContactIDs []uint  `json:"contact_ids"`
+	Doors      int     `json:"doors"` // one per contact
+	Buildings  int     `json:"buildings"`
+	Streets    int     `json:"streets"`
+	Center     Point   `json:"center"`
+	Radius     float64 `json:"radius"`   // from the center to the farthest building, in meters
+	Distance   float64 `json:"distance"` // of the walk through the list, see PlanRoute
+}
+
+// unit is a run of neighbouring buildings of a street, which the partition keeps in the same list.
+// Runs of a quarter of a list leave room enough to balance the lists.
+type unit struct {
+	stops  []int
+	doors  int
+	center Point
+}
+
+// PartitionTerritory cuts the buildings of the contacts into walk lists of about the same number of doors.
+// The streets are cut in runs of buildings which are given to the closest list having room left,
+// the lists being moved to the center of their runs until they settle.
+func PartitionTerritory(contacts []Contact, parts int) (*Partition, error) {
+	var p Partition
+
+	if parts < 1 {
+		return nil, errors.New("partition: at least one walk list is needed")
+	}
+
+	stops, street, unlocated := buildings(contacts)
+	p.Unlocated = unlocated
+	if len(stops) == 0 {
+		return &p, nil
+	}
+	if parts > len(stops) {
+		parts = len(stops)
+	}
+
+	total := 0
+	for _, s := range stops {
+		total += len(s.ContactIDs)
+	}
+	target := float64(total) / float64(parts)
+	units := streetUnits(stops, street, int(math.Ceil(target/4)))
+
+	capacity := int(math.Ceil(target * PartitionSlack))
+	centers := seeds(units, parts)
+	assigned := make([]int, len(units))
+	for pass := 0; pass < PartitionPasses; pass++ {
+		next := assign(units, centers, capacity)
+
+		changed := pass == 0
+		for i := range next {
+			if next[i] != assigned[i] {
+				changed = true
+			}
+		}
+		assigned = next
+		if !changed {
+			break
+		}
+
+		// the lists move to the center of their doors
+		for k := range centers {
+			var lat, lng float64
+			doors := 0
+			for i, u := range units {
+				if assigned[i] == k {
+					lat += u.center.Lat * float64(u.doors)
+					lng += u.center.Lng * float64(u.doors)
+					doors += u.doors
+				}
+			}
+			if doors > 0 {
+				centers[k] = Point{Lat: lat / float64(doors), Lng: lng / float64(doors)}
+			}
+		}
+	}
+
+	for k := 0; k < parts; k++ {
+		var (
+			l       = WalkList{Rank: k + 1, Center: centers[k]}
+			sub     []Stop
+			streets []string
+			seen    = make(map[string]bool)
+		)
+
+		for i, u := range units {
+			if assigned[i] != k {
+				continue
+			}
+			for _, s := range u.stops {
+				sub = append(sub, stops[s])
+				streets = append(streets, street[s])
+				l.ContactIDs = append(l.ContactIDs, stops[s].ContactIDs...)
+				l.Radius = math.Max(l.Radius, walkDistance(l.Center.Lat, l.Center.Lng, stops[s].Lat, stops[s].Lng))
+				if !seen[street[s]] {
+					seen[street[s]] = true
+					l.Streets++
+				}
+			}
+		}
+		if len(sub) == 0 {
+			continue
+		}
+
+		l.Doors, l.Buildings = len(l.ContactIDs), len(sub)
+		l.Distance = walk(sub, streets, nil).Distance
+		p.Lists = append(p.Lists, l)
+	}
+
+	return &p, nil
+}
+
+// streetUnits cuts every street in runs of buildings following the house numbers, of at most size doors
+func streetUnits(stops []Stop, street []string, size int) []unit {
+	var (
+		units    []unit
+		keys     []string
+		byStreet = make(map[string][]int)
+	)
+
+	for i := range stops {
+		if _, ok := byStreet[street[i]]; !ok {
+			keys = append(keys, street[i])
+		}
+		byStreet[street[i]] = append(byStreet[street[i]], i)
+	}
+
+	for _, key := range keys {
+		run := byStreet[key]
+		sort.SliceStable(run, func(i, j int) bool {
+			a, _ := number(stops[run[i]].HouseNumber)
+			b, _ := number(stops[run[j]].HouseNumber)
+			return a < b
+		})
+
+		var u unit
+		for _, s := range run {
+			if u.doors > 0 && u.doors+len(stops[s].ContactIDs) > size {
+				units = append(units, u.centered(stops))
+				u = unit{}
+			}
+			u.stops = append(u.stops, s)
+			u.doors += len(stops[s].ContactIDs)
+		}
+		units = append(units, u.centered(stops))
+	}
+
+	return units
+}
+
+// centered returns the unit with its center, the mean of its doors
+func (u unit) centered(stops []Stop) unit {
+	u.center = Point{}
+	for _, s := range u.stops {
+		u.center.Lat += stops[s].Lat * float64(len(stops[s].ContactIDs))
+		u.center.Lng += stops[s].Lng * float64(len(stops[s].ContactIDs))
+	}
+	u.center.Lat /= float64(u.doors)
+	u.center.Lng /= float64(u.doors)
+
+	return u
+}
+
+// seeds picks the first centers of the lists, each one as far as possible from the ones already picked
+func seeds(units []unit, parts int) []Point {
+	var (
+		centers []Point
+		mean    Point
+		doors   int
+	)
+
+	for _, u := range units {
+		mean.Lat += u.center.Lat * float64(u.doors)
+		mean.Lng += u.center.Lng * float64(u.doors)
+		doors += u.doors
+	}
+	mean.Lat /= float64(doors)
+	mean.Lng /= float64(doors)
+
+	from := []Point{mean}
+	for len(centers) < parts {
+		best, far := 0, -1.0
+		for i, u := range units {
+			near := math.Inf(1)
+			for _, c := range from {
+				near = math.Min(near, walkDistance(c.Lat, c.Lng, u.center.Lat, u.center.Lng))
+			}
+			if near > far {
+				best, far = i, near
+			}
+		}
+		centers = append(centers, units[best].center)
+		if len(centers) == 1 {
+			from = nil
+		}
+		from = append(from, units[best].center)
+	}
+
+	return centers
+}
+
+// assign gives every unit to the closest list having room left for it, or to the least filled list,
+// the largest units being placed first
+func assign(units []unit, centers []Point, capacity int) []int {
+	var (
+		assigned = make([]int, len(units))
+		load     = make([]int, len(centers))
+		order    = make([]int, len(units))
+	)
+
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return units[order[i]].doors > units[order[j]].doors })
+
+	for _, i := range order {
+		u := units[i]
+		lists := make([]int, len(centers))
+		for k := range lists {
+			lists[k] = k
+		}
+		sort.SliceStable(lists, func(a, b int) bool {
+			return walkDistance(centers[lists[a]].Lat, centers[lists[a]].Lng, u.center.Lat, u.center.Lng) <
+				walkDistance(centers[lists[b]].Lat, centers[lists[b]].Lng, u.center.Lat, u.center.Lng)
+		})
+
+		chosen := -1
+		for _, k := range lists {
+			if load[k]+u.doors <= capacity {
+				chosen = k
+				break
+			}
+		}
+		if chosen < 0 {
+			chosen = 0
+			for k := range load {
+				if load[k] < load[chosen] {
+					chosen = k
+				}
+			}
+		}
+		assigned[i] = chosen
+		load[chosen] += u.doors
+	}
+
+	return assigned
+}
@@ -0,0 +1,252 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Parameters of the walk distance estimate
+const (
+	EarthRadius     = 6371000.0 // in meters
+	WalkDetour      = 1.3       // ratio of the walked distance to the straight line, streets not being straight
+	CrossingPenalty = 20.0      // in meters, added to cross a street between its odd and even sides
+	TwoOptPasses    = 50        // improvement passes of the 2-opt, bounding the time spent on large missions
+)
+
+// Sides of a street, given by the parity of the house numbers
+const (
+	SideOdd  = "odd"
+	SideEven = "even"
+)
+
+// Route represents the ordered walk list of a mission, the distances being estimated in meters
+type Route struct {
+	MissionID uint    `json:"mission_id"`
+	Stops     []Stop  `json:"stops"`
+	Distance  float64 `json:"distance"`
+	Unlocated []uint  `json:"unlocated,omitempty"` // the contacts without coordinates, left out of the route
+}
+
+// Stop represents a building of a route, with the contacts living in it
+type Stop struct {
+	Rank        int     `json:"rank"`
+	HouseNumber string  `json:"housenumber,omitempty"`
+	Street      string  `json:"street,omitempty"`
+	City        string  `json:"city,omitempty"`
+	Side        string  `json:"side,omitempty"` // one of SideOdd and SideEven, empty without house number
+	Lat         float64 `json:"lat"`
+	Lng         float64 `json:"lng"`
+	Distance    float64 `json:"distance"` // from the previous stop, or from the start
+	ContactIDs  []uint  `json:"contact_ids"`
+}
+
+// PlanRoute groups the contacts by building and orders the buildings with a nearest neighbour tour improved by 2-opt.
+// The walk starts from the given point, or from the first building of the tour when start is nil.
+func PlanRoute(contacts []Contact, start *Point) *Route {
+	stops, street, unlocated := buildings(contacts)
+
+	route := walk(stops, street, start)
+	route.Unlocated = unlocated
+
+	return route
+}
+
+// walk orders the buildings into a route, street giving the street of each building
+func walk(stops []Stop, street []string, start *Point) *Route {
+	var route Route
+
+	// the order of the contacts must not change the route
+	order := make([]int, len(stops))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return stops[order[i]].ContactIDs[0] < stops[order[j]].ContactIDs[0]
+	})
+
+	dist := func(i, j int) float64 {
+		d := walkDistance(stops[i].Lat, stops[i].Lng, stops[j].Lat, stops[j].Lng)
+		if street[i] == street[j] && stops[i].Side != "" && stops[j].Side != "" && stops[i].Side != stops[j].Side {
+			d += CrossingPenalty
+		}
+		return d
+	}
+	// distance from the start to a stop, none when the walk starts at the first stop
+	fromStart := func(i int) float64 {
+		if start == nil {
+			return 0
+		}
+		return walkDistance(start.Lat, start.Lng, stops[i].Lat, stops[i].Lng)
+	}
+
+	tour := nearestNeighbour(order, dist, fromStart)
+	twoOpt(tour, dist, fromStart)
+
+	for rank, i := range tour {
+		s := stops[i]
+		s.Rank = rank + 1
+		if rank == 0 {
+			s.Distance = fromStart(i)
+		} else {
+			s.Distance = dist(tour[rank-1], i)
+		}
+		route.Distance += s.Distance
+		route.Stops = append(route.Stops, s)
+	}
+
+	return &route
+}
+
+// buildings groups the located contacts by building, returning the street of each building
+// and the contacts without coordinates
+func buildings(contacts []Contact) ([]Stop, []string, []uint) {
+	var (
+		stops     []Stop
+		street    []string
+		unlocated []uint
+		byKey     = make(map[string]int)
+	)
+
+	for _, c := range contacts {
+		a := c.Address
+		lat, errLat := strconv.ParseFloat(strings.TrimSpace(a.Latitude), 64)
+		lng, errLng := strconv.ParseFloat(strings.TrimSpace(a.Longitude), 64)
+		if errLat != nil || errLng != nil {
+			unlocated = append(unlocated, c.ID)
+			continue
+		}
+
+		key := buildingKey(a)
+		if i, ok := byKey[key]; ok {
+			stops[i].ContactIDs = append(stops[i].ContactIDs, c.ID)
+			continue
+		}
+
+		byKey[key] = len(stops)
+		street = append(street, strings.ToLower(strings.TrimSpace(a.Street)+"|"+strings.TrimSpace(a.City)))
+		stops = append(stops, Stop{HouseNumber: a.HouseNumber, Street: a.Street, City: a.City, Side: side(a.HouseNumber), Lat: lat, Lng: lng, ContactIDs: []uint{c.ID}})
+	}
+
+	return stops, street, unlocated
+}
+
+// buildingKey returns the key of the building of an address, from its street or else from its coordinates,
+// empty when the address has neither
+func buildingKey(a Address) string {
+	if strings.TrimSpace(a.Street) != "" {
+		return strings.ToLower(strings.Join([]string{strings.TrimSpace(a.HouseNumber), strings.TrimSpace(a.Street), strings.TrimSpace(a.City)}, "|"))
+	}
+
+	lat, errLat := strconv.ParseFloat(strings.TrimSpace(a.Latitude), 64)
+	lng, errLng := strconv.ParseFloat(strings.TrimSpace(a.Longitude), 64)
+	if errLat != nil || errLng != nil {
+		return ""
+	}
+	return strconv.FormatFloat(lat, 'f', 5, 64) + "," + strconv.FormatFloat(lng, 'f', 5, 64)
+}
+
+// nearestNeighbour builds a tour going each time to the closest stop not visited yet
+func nearestNeighbour(order []int, dist func(int, int) float64, fromStart func(int) float64) []int {
+	var (
+		tour    []int
+		visited = make(map[int]bool)
+	)
+
+	for len(tour) < len(order) {
+		next, best := -1, math.Inf(1)
+		for _, i := range order {
+			if visited[i] {
+				continue
+			}
+			d := fromStart(i)
+			if len(tour) > 0 {
+				d = dist(tour[len(tour)-1], i)
+			}
+			if d < best {
+				next, best = i, d
+			}
+		}
+		visited[next] = true
+		tour = append(tour, next)
+	}
+
+	return tour
+}
+
+// twoOpt shortens an open tour by reversing its segments as long as it gains distance
+func twoOpt(tour []int, dist func(int, int) float64, fromStart func(int) float64) {
+	// edge returns the distance between the positions a and b of the tour, a being -1 for the start
+	edge := func(a, b int) float64 {
+		if b >= len(tour) {
+			return 0
+		}
+		if a < 0 {
+			return fromStart(tour[b])
+		}
+		return dist(tour[a], tour[b])
+	}
+
+	for pass := 0; pass < TwoOptPasses; pass++ {
+		improved := false
+		for i := 0; i < len(tour)-1; i++ {
+			for k := i + 1; k < len(tour); k++ {
+				before := edge(i-1, i) + edge(k, k+1)
+				after := edge(i-1, k) + edge(i, k+1)
+				if after < before-1e-6 {
+					for l, r := i, k; l < r; l, r = l+1, r-1 {
+						tour[l], tour[r] = tour[r], tour[l]
+					}
+					improved = true
+				}
+			}
+		}
+		if !improved {
+			return
+		}
+	}
+}
+
+// walkDistance estimates the walked distance in meters between two points from the great-circle distance
+func walkDistance(lat1, lng1, lat2, lng2 float64) float64 {
+	return greatCircle(lat1, lng1, lat2, lng2) * WalkDetour
+}
+
+// greatCircle returns the distance in meters between two points as the crow flies
+func greatCircle(lat1, lng1, lat2, lng2 float64) float64 {
+	var (
+		rad  = math.Pi / 180
+		dLat = (lat2 - lat1) * rad
+		dLng = (lng2 - lng1) * rad
+	)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+
+	return 2 * EarthRadius * math.Asin(math.Sqrt(h))
+}
+
+// side returns the side of the street of a house number, "12 bis" being on the even side
+func side(houseNumber string) string {
+	n, ok := number(houseNumber)
+	if !ok {
+		return ""
+	}
+	if n%2 == 0 {
+		return SideEven
+	}
+	return SideOdd
+}
+
+// number returns the leading number of a house number, 12 for "12 bis"
+func number(houseNumber string) (int, bool) {
+	digits := strings.TrimSpace(houseNumber)
+	end := 0
+	for end < len(digits) && digits[end] >= '0' && digits[end] <= '9' {
+		end++
+	}
+
+	n, err := strconv.Atoi(digits[:end])
+	return n, err == nil
+}
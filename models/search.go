@@ -7,6 +7,18 @@ type Search struct {
 	Filter  string   `json:"filter,omitempty"`
 	Fields  []string `json:"fields,omitempty"`
 	Polygon []Point  `json:"polygon,omitempty"` //Declared in fact.go
+
+	Consents []string `json:"consents,omitempty"` // channels the contacts must have consented to, see ConsentChannels
+
+	// contacts having one of the tags, all of them, or none of them; the same for the missions
+	Tags            []uint `json:"tags,omitempty"`
+	AllTags         []uint `json:"all_tags,omitempty"`
+	ExcludeTags     []uint `json:"exclude_tags,omitempty"`
+	Missions        []uint `json:"missions,omitempty"`
+	AllMissions     []uint `json:"all_missions,omitempty"`
+	ExcludeMissions []uint `json:"exclude_missions,omitempty"`
+
+	Levels []string `json:"levels,omitempty"` // levels of AggregationContacts, user, date and presence by default
 }
 
 // SearchArgs is used in the RPC communications between the gateway and Contacts
@@ -1,22 +1,28 @@
 // Definition of the structures and SQL interaction functions
 package models
 
-// Tag represents the components of a tag
+// Tag represents the components of a tag, taken from the catalogue of its group where names are unique
 type Tag struct {
-	ID    uint   `gorm:"primary_key" json:"id"`
-	Name  string `json:"name" sql:"not null;"`
-	Color string `json:"color"`
+	ID          uint   `gorm:"primary_key" json:"id"`
+	GroupID     uint   `sql:"index" json:"group_id"`
+	Name        string `json:"name" sql:"not null;"`
+	Category    string `json:"category,omitempty"`
+	Color       string `json:"color"`
+	Description string `json:"description,omitempty"`
 }
 
 // TagArgs is used in the RPC communications between the gateway and Contacts
 type TagArgs struct {
 	GroupID   uint
 	ContactID uint
+	UserID    uint
 	Tag       *Tag
+	TagIDs    []uint // tags merged into Tag
 }
 
 // TagReply is used in the RPC communications between the gateway and Contacts
 type TagReply struct {
-	Tag  *Tag
-	Tags []Tag
+	Tag   *Tag
+	Tags  []Tag
+	Usage map[uint]int // contacts by tag ID
 }
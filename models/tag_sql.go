@@ -3,6 +3,9 @@ package models
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 )
@@ -12,39 +15,434 @@ type TagSQL struct {
 	DB *gorm.DB
 }
 
-// Save inserts a new tag into the database
+// Save inserts a new tag into the catalogue of the group or updates it, and adds it to the contact given by args.ContactID.
+// A new tag named as an existing one is the existing one.
 func (s *TagSQL) Save(t *Tag, args TagArgs) error {
 	if t == nil {
 		return errors.New("save: tag is nil")
 	}
 
-	var c = &Contact{ID: args.ContactID}
+	t.GroupID = args.GroupID
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return errors.New("save: tag has no name")
+	}
+
+	existing, err := tagByName(s.DB, t.GroupID, t.Name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case t.ID == 0 && existing != nil:
+		*t = *existing
+	case t.ID == 0:
+		tx := s.DB.Begin()
+		if err = tx.Create(t).Error; err != nil {
+			tx.Rollback()
+			// the same name was just added to the catalogue, see MigrateTags
+			if existing, _ = tagByName(s.DB, t.GroupID, t.Name); existing == nil {
+				return err
+			}
+			*t = *existing
+			break
+		}
+		if err = recordAudit(tx, t.auditEntry(AuditCreate, TagArgs{GroupID: args.GroupID, UserID: args.UserID}), nil, t); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit().Error; err != nil {
+			return err
+		}
+	default:
+		if existing != nil && existing.ID != t.ID {
+			return fmt.Errorf("save: a tag named %q already exists, merge the tags instead", existing.Name)
+		}
+		var before Tag
+		if err = s.DB.Where("group_id = ? AND id = ?", args.GroupID, t.ID).First(&before).Error; err != nil {
+			if s.DB.Where("group_id = ? AND id = ?", args.GroupID, t.ID).First(&before).RecordNotFound() {
+				return errors.New("save: tag not found")
+			}
+			return err
+		}
+		tx := s.DB.Begin()
+		if err = tx.Save(t).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		// the contacts having the tag show its new name
+		if err = touchTagged(tx, args.GroupID, t.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = recordAudit(tx, t.auditEntry(AuditUpdate, TagArgs{GroupID: args.GroupID, UserID: args.UserID}), &before, t); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err = tx.Commit().Error; err != nil {
+			return err
+		}
+	}
+
+	if args.ContactID == 0 {
+		return nil
+	}
+
+	var c Contact
+	if err = s.DB.Where("group_id = ? AND id = ?", args.GroupID, args.ContactID).First(&c).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.GroupID, args.ContactID).First(&c).RecordNotFound() {
+			return errors.New("save: contact not found")
+		}
+		return err
+	}
 
-	if t.ID == 0 {
-		err := s.DB.Debug().Model(c).Association("Tags").Append(t).Error
-		s.DB.Last(t)
+	// a contact already having the tag is left as it is
+	tx := s.DB.Begin()
+	res := tx.Exec("INSERT INTO contact_tags (contact_id, tag_id) SELECT ?, ? FROM tags WHERE id = ? AND group_id = ? "+
+		"AND NOT EXISTS (SELECT 1 FROM contact_tags WHERE contact_id = ? AND tag_id = ?)", c.ID, t.ID, t.ID, args.GroupID, c.ID, t.ID)
+	if res.Error != nil {
+		tx.Rollback()
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return tx.Commit().Error
+	}
+	if err = touchContacts(tx, args.GroupID, c.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = recordAudit(tx, t.auditEntry(AuditCreate, args), nil, t); err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	return s.DB.Model(c).Association("Tags").Replace(t).Error
+	return tx.Commit().Error
 }
 
-// Delete removes a tag from the database
+// Delete removes a tag from the contact given by args.ContactID, or without contact from the catalogue and all the contacts of the group
 func (s *TagSQL) Delete(t *Tag, args TagArgs) error {
-	return s.DB.Model(&Contact{ID: args.ContactID}).Association("Tags").Delete(t).Error
+	if t == nil {
+		return errors.New("delete: tag is nil")
+	}
+
+	var before Tag
+	if err := s.DB.Where("group_id = ? AND id = ?", args.GroupID, t.ID).First(&before).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.GroupID, t.ID).First(&before).RecordNotFound() {
+			return errors.New("delete: tag not found")
+		}
+		return err
+	}
+
+	if args.ContactID != 0 {
+		// a contact of another group, or not having the tag, is left as it is
+		tx := s.DB.Begin()
+		res := tx.Exec("DELETE FROM contact_tags WHERE contact_id = ? AND tag_id = ? AND contact_id IN (SELECT id FROM contacts WHERE group_id = ?)", args.ContactID, before.ID, args.GroupID)
+		if res.Error != nil {
+			tx.Rollback()
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return tx.Commit().Error
+		}
+		if err := touchContacts(tx, args.GroupID, args.ContactID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := recordAudit(tx, before.auditEntry(AuditDelete, args), &before, nil); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit().Error
+	}
+
+	var contactIDs []uint
+	if err := s.DB.Table("contact_tags").Where("tag_id = ?", before.ID).Pluck("contact_id", &contactIDs).Error; err != nil {
+		return err
+	}
+
+	tx := s.DB.Begin()
+	if err := touchContacts(tx, args.GroupID, contactIDs...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Exec("DELETE FROM contact_tags WHERE tag_id = ?", before.ID).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	// the tag is taken off each of its contacts
+	for _, id := range contactIDs {
+		if err := recordAudit(tx, before.auditEntry(AuditDelete, TagArgs{GroupID: args.GroupID, ContactID: id, UserID: args.UserID}), &before, nil); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Delete(&before).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := recordAudit(tx, before.auditEntry(AuditDelete, args), &before, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
 }
 
-// Find return all the tags containing a given groupID from the database
-func (s *TagSQL) Find(args TagArgs) ([]Tag, error) {
+// Rename gives a new name to a tag of the catalogue, on all the contacts having it
+func (s *TagSQL) Rename(args TagArgs) error {
+	if args.Tag == nil || args.Tag.ID == 0 {
+		return errors.New("rename: no tag given")
+	}
+
+	var t Tag
+	if err := s.DB.Where("group_id = ? AND id = ?", args.GroupID, args.Tag.ID).First(&t).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.GroupID, args.Tag.ID).First(&t).RecordNotFound() {
+			return errors.New("rename: tag not found")
+		}
+		return err
+	}
+	t.Name = args.Tag.Name
+
+	if err := s.Save(&t, TagArgs{GroupID: args.GroupID, UserID: args.UserID}); err != nil {
+		return err
+	}
+	*args.Tag = t
+
+	return nil
+}
+
+// Merge moves the contacts of the tags given by args.TagIDs to args.Tag, and removes the merged tags from the catalogue
+func (s *TagSQL) Merge(args TagArgs) error {
+	if args.Tag == nil || args.Tag.ID == 0 {
+		return errors.New("merge: no tag given")
+	}
+
 	var (
-		tags []Tag
-		c    = &Contact{ID: args.ContactID}
+		into Tag
+		from []Tag
 	)
 
-	err := s.DB.Model(c).Association("Tags").Find(&tags).Error
+	if err := s.DB.Where("group_id = ? AND id = ?", args.GroupID, args.Tag.ID).First(&into).Error; err != nil {
+		if s.DB.Where("group_id = ? AND id = ?", args.GroupID, args.Tag.ID).First(&into).RecordNotFound() {
+			return errors.New("merge: tag not found")
+		}
+		return err
+	}
+
+	var ids []uint
+	for _, id := range args.TagIDs {
+		if id != into.ID {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := s.DB.Where("group_id = ? AND id IN (?)", args.GroupID, ids).Find(&from).Error; err != nil {
+		return err
+	}
+	if len(from) != len(ids) {
+		return errors.New("merge: tag not found")
+	}
+
+	tx := s.DB.Begin()
+	for i := range from {
+		if err := touchTagged(tx, args.GroupID, from[i].ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := mergeTag(tx, into.ID, from[i].ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := recordAudit(tx, from[i].auditEntry(AuditDelete, TagArgs{GroupID: args.GroupID, UserID: args.UserID}), &from[i], nil); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	*args.Tag = into
+
+	return tx.Commit().Error
+}
+
+// touchTagged sets the last change of the contacts of a group having a tag to now, see touchContacts
+func touchTagged(db *gorm.DB, groupID uint, tagID uint) error {
+	return db.Model(&Contact{}).Where("group_id = ? AND id IN (SELECT contact_id FROM contact_tags WHERE tag_id = ?)", groupID, tagID).UpdateColumn("last_change", time.Now()).Error
+}
+
+// Usage returns the number of contacts of the group having each tag of its catalogue, the contacts in the trash left out
+func (s *TagSQL) Usage(args TagArgs) (map[uint]int, error) {
+	usage := make(map[uint]int)
+
+	rows, err := s.DB.Raw(`SELECT tags.id, COUNT(contacts.id) FROM tags
+		LEFT JOIN contact_tags ON contact_tags.tag_id = tags.id
+		LEFT JOIN contacts ON contacts.id = contact_tags.contact_id AND contacts.deleted_at IS NULL
+		WHERE tags.group_id = ? GROUP BY tags.id`, args.GroupID).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uint
+		var count int
+		if err = rows.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		usage[id] = count
+	}
+
+	return usage, rows.Err()
+}
+
+// ContactIDs returns the contacts having args.Tag or one of the tags given by args.TagIDs
+func (s *TagSQL) ContactIDs(args TagArgs) ([]uint, error) {
+	var ids []uint
+
+	tagIDs := args.TagIDs
+	if args.Tag != nil {
+		tagIDs = append(tagIDs, args.Tag.ID)
+	}
+	if len(tagIDs) == 0 {
+		return ids, nil
+	}
+
+	err := s.DB.Model(&Contact{}).Where("group_id = ? AND id IN (SELECT contact_id FROM contact_tags WHERE tag_id IN (?))", args.GroupID, tagIDs).Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// auditEntry returns the audit log entry of a tag added to or removed from a contact, or of a change to the catalogue without contact
+func (t *Tag) auditEntry(action string, args TagArgs) AuditEntry {
+	return AuditEntry{GroupID: args.GroupID, Entity: "tag", EntityID: t.ID, ContactID: args.ContactID, Action: action, UserID: args.UserID}
+}
+
+// Find return the tags of the contact given by args.ContactID, or without contact the catalogue of the group
+func (s *TagSQL) Find(args TagArgs) ([]Tag, error) {
+	var tags []Tag
+
+	if args.ContactID == 0 {
+		if err := s.DB.Where("group_id = ?", args.GroupID).Order("category, name").Find(&tags).Error; err != nil {
+			return nil, err
+		}
+		return tags, nil
+	}
+
+	err := s.DB.Model(&Contact{ID: args.ContactID}).Association("Tags").Find(&tags).Error
 	if err != nil {
 		return nil, err
 	}
 
 	return tags, nil
 }
+
+// tagByName returns the tag of the catalogue of a group having the name, whatever its case
+func tagByName(db *gorm.DB, groupID uint, name string) (*Tag, error) {
+	var t Tag
+
+	if err := db.Where("group_id = ? AND LOWER(name) = LOWER(?)", groupID, strings.TrimSpace(name)).First(&t).Error; err != nil {
+		if db.Where("group_id = ? AND LOWER(name) = LOWER(?)", groupID, strings.TrimSpace(name)).First(&t).RecordNotFound() {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// catalogueTags replaces the tags of a contact by the tags of the catalogue of its group having the same names,
+// the missing ones being added to the catalogue
+func catalogueTags(db *gorm.DB, c *Contact) error {
+	for i := range c.Tags {
+		t, err := tagByName(db, c.GroupID, c.Tags[i].Name)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			c.Tags[i].ID = 0
+			if err = (&TagSQL{DB: db}).Save(&c.Tags[i], TagArgs{GroupID: c.GroupID, UserID: c.LastChangeUserID}); err != nil {
+				return err
+			}
+			continue
+		}
+		c.Tags[i] = *t
+	}
+
+	return nil
+}
+
+// mergeTag moves the contacts of a tag to another one and removes it, the contacts having both keeping one
+func mergeTag(db *gorm.DB, into uint, from uint) error {
+	if err := db.Exec("DELETE FROM contact_tags WHERE tag_id = ? AND contact_id IN (SELECT contact_id FROM (SELECT contact_id FROM contact_tags WHERE tag_id = ?) AS tagged)", from, into).Error; err != nil {
+		return err
+	}
+	if err := db.Exec("UPDATE contact_tags SET tag_id = ? WHERE tag_id = ?", into, from).Error; err != nil {
+		return err
+	}
+
+	return db.Where("id = ?", from).Delete(&Tag{}).Error
+}
+
+// MigrateTags gives their group to the tags created before the catalogues, a tag shared by several groups being copied
+// into each of them, then merges the tags of a group having the same name and keeps the names unique
+func MigrateTags(db *gorm.DB) error {
+	var tags []Tag
+
+	if err := db.Where("group_id = 0 OR group_id IS NULL").Find(&tags).Error; err != nil {
+		return err
+	}
+
+	for _, t := range tags {
+		var groups []uint
+		if err := db.Model(&Contact{}).Unscoped().Where("id IN (SELECT contact_id FROM contact_tags WHERE tag_id = ?)", t.ID).Pluck("DISTINCT group_id", &groups).Error; err != nil {
+			return err
+		}
+		for i, groupID := range groups {
+			if i == 0 {
+				if err := db.Model(&Tag{}).Where("id = ?", t.ID).UpdateColumn("group_id", groupID).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			cp := t
+			cp.ID, cp.GroupID = 0, groupID
+			if err := db.Create(&cp).Error; err != nil {
+				return err
+			}
+			if err := db.Exec("UPDATE contact_tags SET tag_id = ? WHERE tag_id = ? AND contact_id IN (SELECT id FROM contacts WHERE group_id = ?)", cp.ID, t.ID, groupID).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := db.Order("id").Find(&tags).Error; err != nil {
+		return err
+	}
+	kept := make(map[string]uint)
+	for _, t := range tags {
+		key := fmt.Sprintf("%d/%s", t.GroupID, strings.ToLower(strings.TrimSpace(t.Name)))
+		into, ok := kept[key]
+		if !ok {
+			kept[key] = t.ID
+			continue
+		}
+		if err := mergeTag(db, into, t.ID); err != nil {
+			return err
+		}
+	}
+
+	// the names are unique whatever their case, which the collation of MySQL already ignores
+	dialect := db.NewScope(&Tag{}).Dialect()
+	if dialect.HasIndex("tags", "uix_tags_group_name") {
+		return nil
+	}
+	columns := "group_id, LOWER(name)"
+	if dialect.GetName() == "mysql" {
+		columns = "group_id, name"
+	}
+
+	return db.Exec("CREATE UNIQUE INDEX uix_tags_group_name ON tags (" + columns + ")").Error
+}
@@ -8,6 +8,11 @@ type TagDS interface {
 	Save(*Tag, TagArgs) error
 	Delete(*Tag, TagArgs) error
 	Find(TagArgs) ([]Tag, error)
+
+	Rename(TagArgs) error
+	Merge(TagArgs) error
+	Usage(TagArgs) (map[uint]int, error)
+	ContactIDs(TagArgs) ([]uint, error)
 }
 
 // Tagstore returns a TagDS implementing CRUD methods for the tags and containing a gorm client
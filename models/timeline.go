@@ -0,0 +1,55 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "time"
+
+// Types of the entries of the timeline of a contact
+const (
+	TimelineNote     = "note"
+	TimelineFact     = "fact"
+	TimelineFormdata = "formdata"
+	TimelineTag      = "tag"
+	TimelineMission  = "mission"
+)
+
+// TimelineTypes lists the types of the entries of the timeline of a contact
+var TimelineTypes = []string{TimelineNote, TimelineFact, TimelineFormdata, TimelineTag, TimelineMission}
+
+// What happened to the tags and the missions of a contact
+const (
+	TimelineAdded   = "added"
+	TimelineRemoved = "removed"
+)
+
+// TimelinePageSize is the number of entries of a timeline returned at once by default
+const TimelinePageSize = 50
+
+// TimelineEntry represents something which happened with a contact, the field named after its type being set
+type TimelineEntry struct {
+	Type   string    `json:"type"` // one of TimelineTypes
+	Date   time.Time `json:"date"`
+	UserID uint      `json:"user_id,omitempty"`
+	Action string    `json:"action,omitempty"` // TimelineAdded or TimelineRemoved, for the tags and the missions
+
+	Note     *Note     `json:"note,omitempty"`
+	Fact     *Fact     `json:"fact,omitempty"`
+	Formdata *Formdata `json:"formdata,omitempty"`
+	Tag      *Tag      `json:"tag,omitempty"`     // as it was when the change was made
+	Mission  *Mission  `json:"mission,omitempty"` // as it was when the change was made
+}
+
+// TimelineArgs is used in the RPC communications between the gateway and Contacts
+type TimelineArgs struct {
+	GroupID   uint
+	ContactID uint
+	UserID    uint
+	Types     []string // the types of the entries returned, all of them by default
+	From      int      // first entry returned,
+	Size      int      // and how many, TimelinePageSize by default
+}
+
+// TimelineReply is used in the RPC communications between the gateway and Contacts
+type TimelineReply struct {
+	Entries []TimelineEntry
+	Total   int // entries of the requested types
+}
@@ -0,0 +1,156 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// TimelineSQL contains a Gorm client and the timeline and gorm related methods
+type TimelineSQL struct {
+	DB *gorm.DB
+}
+
+// Find returns a page of the timeline of a contact of the group, the latest entries first, and the number of its entries.
+// The tags and the missions come from the audit log, their changes being listed from the time they were recorded.
+func (s *TimelineSQL) Find(args TimelineArgs) ([]TimelineEntry, int, error) {
+	var (
+		entries []TimelineEntry
+		audit   []AuditEntry
+		wanted  = make(map[string]bool)
+		created = make(map[string]AuditEntry)
+	)
+
+	for _, t := range args.Types {
+		if !contains(TimelineTypes, t) {
+			return nil, 0, fmt.Errorf("timeline: unknown type %q", t)
+		}
+		wanted[t] = true
+	}
+	if len(args.Types) == 0 {
+		for _, t := range TimelineTypes {
+			wanted[t] = true
+		}
+	}
+
+	if s.DB.Where("group_id = ? AND id = ?", args.GroupID, args.ContactID).First(&Contact{}).RecordNotFound() {
+		return nil, 0, errors.New("timeline: contact not found")
+	}
+
+	// the audit log gives the author of the notes, facts and answers, and their date when they have none
+	err := s.DB.Where("group_id = ? AND contact_id = ? AND entity IN (?) AND action IN (?)", args.GroupID, args.ContactID, TimelineTypes, []string{AuditCreate, AuditDelete}).
+		Order("date, id").Find(&audit).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, e := range audit {
+		if e.Action == AuditCreate {
+			created[e.Entity+":"+strconv.Itoa(int(e.EntityID))] = e
+		}
+	}
+	// entry returns an entry dated by date, or by the creation of the entity without it
+	entry := func(typ string, id uint, date *time.Time, userID uint) TimelineEntry {
+		e := TimelineEntry{Type: typ, UserID: userID}
+		c, ok := created[typ+":"+strconv.Itoa(int(id))]
+		if ok && e.UserID == 0 {
+			e.UserID = c.UserID
+		}
+		if date != nil {
+			e.Date = *date
+		} else if ok {
+			e.Date = c.Date
+		}
+		return e
+	}
+
+	if wanted[TimelineNote] {
+		var notes []Note
+		if err = s.DB.Where("group_id = ? AND contact_id = ?", args.GroupID, args.ContactID).Find(&notes).Error; err != nil {
+			return nil, 0, err
+		}
+		for i := range notes {
+			e := entry(TimelineNote, notes[i].ID, notes[i].Date, 0)
+			e.Note = &notes[i]
+			entries = append(entries, e)
+		}
+	}
+
+	if wanted[TimelineFact] {
+		var facts []Fact
+		if err = s.DB.Where("group_id = ? AND contact_id = ?", args.GroupID, args.ContactID).Find(&facts).Error; err != nil {
+			return nil, 0, err
+		}
+		for i := range facts {
+			date := facts[i].OccurredAt
+			if date == nil {
+				date = facts[i].CreatedAt
+			}
+			e := entry(TimelineFact, facts[i].ID, date, facts[i].UserID)
+			e.Fact = &facts[i]
+			entries = append(entries, e)
+		}
+	}
+
+	if wanted[TimelineFormdata] {
+		var formdatas []Formdata
+		if err = s.DB.Where("group_id = ? AND contact_id = ?", args.GroupID, args.ContactID).Find(&formdatas).Error; err != nil {
+			return nil, 0, err
+		}
+		for i := range formdatas {
+			e := entry(TimelineFormdata, formdatas[i].ID, formdatas[i].Date, 0)
+			e.Formdata = &formdatas[i]
+			entries = append(entries, e)
+		}
+	}
+
+	for _, a := range audit {
+		if (a.Entity != TimelineTag && a.Entity != TimelineMission) || !wanted[a.Entity] {
+			continue
+		}
+
+		e := TimelineEntry{Type: a.Entity, Date: a.Date, UserID: a.UserID, Action: TimelineAdded}
+		if a.Action == AuditDelete {
+			e.Action = TimelineRemoved
+		}
+		if a.Entity == TimelineTag {
+			e.Tag = &Tag{}
+			err = json.Unmarshal([]byte(a.Snapshot), e.Tag)
+		} else {
+			e.Mission = &Mission{}
+			err = json.Unmarshal([]byte(a.Snapshot), e.Mission)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+	}
+
+	// the latest first, the entries of the same date in the order they were listed
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Date.After(entries[j].Date) })
+
+	return pageTimeline(entries, args.From, args.Size), len(entries), nil
+}
+
+// pageTimeline returns size entries from the index from, TimelinePageSize when size is not given
+func pageTimeline(entries []TimelineEntry, from int, size int) []TimelineEntry {
+	if size <= 0 {
+		size = TimelinePageSize
+	}
+	if from < 0 {
+		from = 0
+	}
+
+	if from >= len(entries) {
+		return nil
+	}
+	if from+size > len(entries) {
+		return entries[from:]
+	}
+	return entries[from : from+size]
+}
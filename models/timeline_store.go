@@ -0,0 +1,14 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import "github.com/jinzhu/gorm"
+
+// TimelineDS implements the TimelineSQL methods
+type TimelineDS interface {
+	Find(TimelineArgs) ([]TimelineEntry, int, error)
+}
+
+// TimelineStore returns a TimelineDS reading the timelines of the contacts and containing a gorm client
+func TimelineStore(db *gorm.DB) TimelineDS {
+	return &TimelineSQL{DB: db}
+}
@@ -0,0 +1,34 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+// Types of the items which can be moved to the trash
+const (
+	TrashContact  = "contact"
+	TrashNote     = "note"
+	TrashFormdata = "formdata"
+	TrashFact     = "fact"
+	TrashMission  = "mission"
+)
+
+// Trash represents the soft deleted items of a group
+type Trash struct {
+	Contacts  []Contact  `json:"contacts,omitempty"`
+	Notes     []Note     `json:"notes,omitempty"`
+	Formdatas []Formdata `json:"formdatas,omitempty"`
+	Facts     []Fact     `json:"facts,omitempty"`
+	Missions  []Mission  `json:"missions,omitempty"`
+}
+
+// TrashArgs is used in the RPC communications between the gateway and Contacts
+type TrashArgs struct {
+	GroupID uint
+	Type    string
+	ID      uint
+	UserID  uint
+}
+
+// TrashReply is used in the RPC communications between the gateway and Contacts
+type TrashReply struct {
+	Trash      *Trash
+	ContactIDs []uint
+}
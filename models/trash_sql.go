@@ -0,0 +1,242 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// TrashSQL contains a Gorm client and the trash and gorm related methods
+type TrashSQL struct {
+	DB *gorm.DB
+}
+
+// Find returns the soft deleted items of a group, restricted to one type if args.Type is set
+func (s *TrashSQL) Find(args TrashArgs) (*Trash, error) {
+	var (
+		t  Trash
+		db = s.DB.Unscoped().Where("group_id = ? AND deleted_at IS NOT NULL", args.GroupID).Order("deleted_at desc")
+	)
+
+	if args.Type == "" || args.Type == TrashContact {
+		if err := db.Preload("Address").Find(&t.Contacts).Error; err != nil {
+			return nil, err
+		}
+	}
+	if args.Type == "" || args.Type == TrashNote {
+		if err := db.Find(&t.Notes).Error; err != nil {
+			return nil, err
+		}
+	}
+	if args.Type == "" || args.Type == TrashFormdata {
+		if err := db.Find(&t.Formdatas).Error; err != nil {
+			return nil, err
+		}
+	}
+	if args.Type == "" || args.Type == TrashFact {
+		if err := db.Find(&t.Facts).Error; err != nil {
+			return nil, err
+		}
+	}
+	if args.Type == "" || args.Type == TrashMission {
+		if err := db.Find(&t.Missions).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+// Restore takes an item out of the trash, a contact comes back with the notes, formdatas and facts deleted along with it
+func (s *TrashSQL) Restore(args TrashArgs) error {
+	var before, after interface{}
+
+	switch args.Type {
+	case TrashContact:
+		return s.restoreContact(args)
+	case TrashNote:
+		before, after = &Note{}, &Note{}
+	case TrashFormdata:
+		before, after = &Formdata{}, &Formdata{}
+	case TrashFact:
+		before, after = &Fact{}, &Fact{}
+	case TrashMission:
+		before, after = &Mission{}, &Mission{}
+	default:
+		return fmt.Errorf("restore: unknown type %q", args.Type)
+	}
+
+	trashed := s.DB.Unscoped().Where("group_id = ? AND id = ? AND deleted_at IS NOT NULL", args.GroupID, args.ID)
+	if err := trashed.First(before).Error; err != nil {
+		if trashed.First(before).RecordNotFound() {
+			return errors.New("restore: item is not in the trash")
+		}
+		return err
+	}
+
+	tx := s.DB.Begin()
+	if err := tx.Unscoped().Model(before).Where("group_id = ? AND id = ?", args.GroupID, args.ID).UpdateColumn("deleted_at", nil).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Where("id = ?", args.ID).First(after).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	e := AuditEntry{GroupID: args.GroupID, Entity: args.Type, EntityID: args.ID, Action: AuditRestore, UserID: args.UserID}
+	if args.Type != TrashMission {
+		var contactIDs []uint
+		if err := tx.Model(after).Where("id = ?", args.ID).Pluck("contact_id", &contactIDs).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+		if len(contactIDs) > 0 {
+			e.ContactID = contactIDs[0]
+		}
+		if err := touchContacts(tx, args.GroupID, e.ContactID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := recordAudit(tx, e, before, after); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+func (s *TrashSQL) restoreContact(args TrashArgs) error {
+	var c Contact
+
+	if err := s.DB.Unscoped().Where("group_id = ? AND id = ? AND deleted_at IS NOT NULL", args.GroupID, args.ID).Preload("Address").First(&c).Error; err != nil {
+		if s.DB.Unscoped().Where("group_id = ? AND id = ? AND deleted_at IS NOT NULL", args.GroupID, args.ID).First(&c).RecordNotFound() {
+			return errors.New("restore: contact is not in the trash")
+		}
+		return err
+	}
+
+	tx := s.DB.Begin()
+	for _, value := range []interface{}{&Note{}, &Formdata{}, &Fact{}} {
+		if err := tx.Unscoped().Model(value).Where("group_id = ? AND contact_id = ? AND deleted_at = ?", args.GroupID, c.ID, *c.DeletedAt).UpdateColumn("deleted_at", nil).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Unscoped().Model(&Contact{}).Where("group_id = ? AND id = ?", args.GroupID, c.ID).UpdateColumn("deleted_at", nil).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := touchContacts(tx, args.GroupID, c.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	restored := c
+	restored.DeletedAt = nil
+	if err := recordAudit(tx, restored.auditEntry(AuditRestore, ContactArgs{UserID: args.UserID}), &c, &restored); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// Purge permanently removes the items of a group deleted before the given date.
+// Purged contacts take their addresses, methods, consents, notes, formdatas, facts, tag and mission links with them;
+// their IDs are returned so that they can be removed from the search indices.
+func (s *TrashSQL) Purge(args TrashArgs, before time.Time) ([]uint, error) {
+	var (
+		ids        []uint
+		addressIDs []uint
+		missionIDs []uint
+		tx         = s.DB.Begin()
+	)
+
+	expired := tx.Unscoped().Where("group_id = ? AND deleted_at IS NOT NULL AND deleted_at < ?", args.GroupID, before)
+	if err := expired.Model(&Contact{}).Pluck("id", &ids).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := expired.Model(&Mission{}).Pluck("id", &missionIDs).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if len(ids) > 0 {
+		if err := tx.Unscoped().Model(&Contact{}).Where("id IN (?)", ids).Pluck("address_id", &addressIDs).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		var otherAddressIDs []uint
+		if err := tx.Model(&ContactAddress{}).Where("contact_id IN (?)", ids).Pluck("address_id", &otherAddressIDs).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		addressIDs = append(addressIDs, otherAddressIDs...)
+
+		cascade := []func() error{
+			func() error { return tx.Unscoped().Where("contact_id IN (?)", ids).Delete(&Note{}).Error },
+			func() error { return tx.Unscoped().Where("contact_id IN (?)", ids).Delete(&Formdata{}).Error },
+			func() error { return tx.Unscoped().Where("contact_id IN (?)", ids).Delete(&Fact{}).Error },
+			func() error { return tx.Where("contact_id IN (?)", ids).Delete(&ContactMethod{}).Error },
+			func() error { return tx.Where("contact_id IN (?)", ids).Delete(&ContactAddress{}).Error },
+			func() error { return tx.Where("contact_id IN (?)", ids).Delete(&Consent{}).Error },
+			func() error { return tx.Exec("DELETE FROM contact_tags WHERE contact_id IN (?)", ids).Error },
+			func() error { return tx.Exec("DELETE FROM mission_contacts WHERE contact_id IN (?)", ids).Error },
+			func() error { return tx.Unscoped().Where("id IN (?)", ids).Delete(&Contact{}).Error },
+		}
+		if len(addressIDs) > 0 {
+			cascade = append(cascade, func() error { return tx.Where("id IN (?)", addressIDs).Delete(&Address{}).Error })
+		}
+		for _, f := range cascade {
+			if err := f(); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+	}
+
+	if len(missionIDs) > 0 {
+		if err := tx.Exec("DELETE FROM mission_contacts WHERE mission_id IN (?)", missionIDs).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	for _, value := range []interface{}{&Note{}, &Formdata{}, &Fact{}, &Mission{}} {
+		if err := expired.Delete(value).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return ids, tx.Commit().Error
+}
+
+// Groups returns the IDs of the groups having items in the trash
+func (s *TrashSQL) Groups() ([]uint, error) {
+	var (
+		groups = make(map[uint]bool)
+		ids    []uint
+	)
+
+	for _, value := range []interface{}{&Contact{}, &Note{}, &Formdata{}, &Fact{}, &Mission{}} {
+		var groupIDs []uint
+		if err := s.DB.Unscoped().Model(value).Where("deleted_at IS NOT NULL").Pluck("DISTINCT group_id", &groupIDs).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range groupIDs {
+			if !groups[id] {
+				groups[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	return ids, nil
+}
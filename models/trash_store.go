@@ -0,0 +1,21 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// TrashDS implements the TrashSQL methods
+type TrashDS interface {
+	Find(TrashArgs) (*Trash, error)
+	Restore(TrashArgs) error
+	Purge(TrashArgs, time.Time) ([]uint, error)
+	Groups() ([]uint, error)
+}
+
+// TrashStore returns a TrashDS implementing the trash methods and containing a gorm client
+func TrashStore(db *gorm.DB) TrashDS {
+	return &TrashSQL{DB: db}
+}
@@ -0,0 +1,156 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/jinzhu/gorm"
+)
+
+// DefaultPhoneRegion is the country calling code given to the phone numbers written without one
+const DefaultPhoneRegion = "33"
+
+var (
+	rxFrenchPostalCode = regexp.MustCompile(`^(0[1-9]|[1-8][0-9]|9[0-8])[0-9]{3}$`)
+	rxPostalCode       = regexp.MustCompile(`^[A-Z0-9][A-Z0-9 -]{1,8}[A-Z0-9]$`)
+	rxE164             = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+
+	// genders maps the accepted spellings to the stored vocabulary, M or F
+	genders = map[string]string{
+		"m": "M", "h": "M", "homme": "M", "male": "M", "masculin": "M",
+		"f": "F", "femme": "F", "female": "F", "féminin": "F", "feminin": "F",
+	}
+)
+
+// ValidationError is returned by the saves refused in a group validating strictly, Fields maps the invalid fields to the reason
+type ValidationError struct {
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	var fields []string
+	for k, v := range e.Fields {
+		fields = append(fields, k+" "+v)
+	}
+	sort.Strings(fields)
+
+	return "validation: " + strings.Join(fields, ", ")
+}
+
+// IsValidation tells whether an error is a refused save
+func IsValidation(err error) bool {
+	_, ok := err.(*ValidationError)
+	return ok
+}
+
+// strictValidation tells whether the group refuses the invalid contacts and answers, see GroupSetting
+func strictValidation(db *gorm.DB, groupID uint) (bool, error) {
+	gs, err := GroupSettingStore(db).First(GroupSettingArgs{GroupSetting: &GroupSetting{GroupID: groupID}})
+	if err != nil {
+		return false, err
+	}
+
+	return gs.StrictValidation, nil
+}
+
+// channelStatus returns the validity status of a contact method or address, keeping the ones set by the clients
+func channelStatus(status string, valid bool) string {
+	if status != "" && status != ChannelValid && status != ChannelInvalid {
+		return status
+	}
+	if valid {
+		return ChannelValid
+	}
+
+	return ChannelInvalid
+}
+
+// normalizeMail trims and lowercases an email address, returning false if it isn't one
+func normalizeMail(mail string) (string, bool) {
+	mail = strings.ToLower(strings.TrimSpace(mail))
+	return mail, govalidator.IsEmail(mail)
+}
+
+// normalizePhone writes a phone number in the E.164 format, the numbers without
+// international prefix being given the DefaultPhoneRegion: "06 12 34 56 78" becomes "+33612345678"
+func normalizePhone(phone string) (string, bool) {
+	var digits []rune
+
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = append(digits, r)
+		case r == '+' && i == 0:
+			digits = append(digits, r)
+		case r == ' ' || r == '.' || r == '-' || r == '(' || r == ')' || r == '/':
+		default:
+			return phone, false
+		}
+	}
+
+	n := string(digits)
+	switch {
+	case strings.HasPrefix(n, "+"):
+	case strings.HasPrefix(n, "00"):
+		n = "+" + n[2:]
+	case strings.HasPrefix(n, "0") && len(n) == 10:
+		n = "+" + DefaultPhoneRegion + n[1:]
+	default:
+		return phone, false
+	}
+	// the trunk prefix sometimes kept after the country code, as in +33 (0)6...
+	if strings.HasPrefix(n, "+"+DefaultPhoneRegion+"0") {
+		n = "+" + DefaultPhoneRegion + n[len(DefaultPhoneRegion)+2:]
+	}
+
+	if !rxE164.MatchString(n) {
+		return phone, false
+	}
+	if strings.HasPrefix(n, "+"+DefaultPhoneRegion) && len(n) != len(DefaultPhoneRegion)+10 {
+		return phone, false
+	}
+
+	return n, true
+}
+
+// normalizeGender maps a gender to the stored vocabulary
+func normalizeGender(gender string) (string, bool) {
+	g, ok := genders[strings.ToLower(strings.TrimSpace(gender))]
+	if !ok {
+		return gender, false
+	}
+
+	return g, true
+}
+
+// checkBirthdate refuses the birthdates in the future or more than 120 years ago
+func checkBirthdate(birthdate time.Time) error {
+	now := time.Now()
+
+	if birthdate.After(now) {
+		return errors.New("is in the future")
+	}
+	if birthdate.Before(now.AddDate(-120, 0, 0)) {
+		return errors.New("is more than 120 years ago")
+	}
+
+	return nil
+}
+
+// normalizePostalCode checks a postal code against the format of its country, France when not given
+func normalizePostalCode(code string, country string) (string, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	switch strings.ToLower(strings.TrimSpace(country)) {
+	case "", "fr", "fra", "france":
+		code = strings.Replace(code, " ", "", -1)
+		return code, rxFrenchPostalCode.MatchString(code)
+	}
+
+	return code, rxPostalCode.MatchString(code)
+}
@@ -0,0 +1,552 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// vCardUIDPrefix prefixes the contact ID in the UID property of the exported vCards
+const vCardUIDPrefix = "urn:quorums:contact:"
+
+// VCardArgs is used in the RPC communications between the gateway and Contacts
+type VCardArgs struct {
+	GroupID   uint
+	UserID    uint
+	ContactID uint
+	MissionID uint
+	IDs       []uint
+	Data      string
+}
+
+// VCardReply is used in the RPC communications between the gateway and Contacts
+type VCardReply struct {
+	Data     string
+	Contact  *Contact
+	Contacts []Contact
+	Job      *BulkJob // the import, which can be rolled back with Bulk.Rollback
+}
+
+// ImportError is returned when an import failed after saving some of the contacts, it carries the import
+// job recording them so that the client can roll them back
+type ImportError struct {
+	JobID uint
+	Err   string
+}
+
+const importErrorFormat = "import: job %d holds the contacts saved before the failure: "
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf(importErrorFormat, e.JobID) + e.Err
+}
+
+// AsImportError returns the failed import behind an error, net/rpc only carrying the message of the errors
+func AsImportError(err error) (*ImportError, bool) {
+	if err == nil {
+		return nil, false
+	}
+	if e, ok := err.(*ImportError); ok {
+		return e, true
+	}
+
+	var e ImportError
+	if n, _ := fmt.Sscanf(err.Error(), importErrorFormat, &e.JobID); n != 1 {
+		return nil, false
+	}
+	e.Err = strings.TrimPrefix(err.Error(), fmt.Sprintf(importErrorFormat, e.JobID))
+
+	return &e, true
+}
+
+// VCard serializes the contact as a vCard 4.0 (RFC 6350), notes and tags included
+func (c *Contact) VCard() string {
+	var b strings.Builder
+
+	writeVCardLine(&b, "BEGIN:VCARD")
+	writeVCardLine(&b, "VERSION:4.0")
+	if c.ID != 0 {
+		writeVCardLine(&b, "UID:"+vCardUIDPrefix+strconv.Itoa(int(c.ID)))
+	}
+	writeVCardLine(&b, "FN:"+escapeVCard(strings.TrimSpace(c.Firstname+" "+c.Surname)))
+	writeVCardLine(&b, "N:"+joinVCard(c.Surname, c.Firstname, "", "", ""))
+	if c.MarriedName != nil && *c.MarriedName != "" {
+		writeVCardLine(&b, "X-MARRIED-NAME:"+escapeVCard(*c.MarriedName))
+	}
+	if c.Gender != nil && *c.Gender != "" {
+		writeVCardLine(&b, "GENDER:"+escapeVCard(*c.Gender))
+	}
+	if c.Birthdate != nil {
+		writeVCardLine(&b, "BDAY:"+c.Birthdate.Format("20060102"))
+	}
+	if len(c.Methods) > 0 {
+		for _, m := range c.Methods {
+			writeVCardLine(&b, vCardMethod(m))
+		}
+	} else {
+		if c.Mail != nil && *c.Mail != "" {
+			writeVCardLine(&b, "EMAIL:"+escapeVCard(*c.Mail))
+		}
+		if c.Phone != nil && *c.Phone != "" {
+			writeVCardLine(&b, "TEL;TYPE=home,voice:"+escapeVCard(*c.Phone))
+		}
+		if c.Mobile != nil && *c.Mobile != "" {
+			writeVCardLine(&b, "TEL;TYPE=cell:"+escapeVCard(*c.Mobile))
+		}
+	}
+
+	var primaryType string
+	for _, ca := range c.Addresses {
+		if ca.Primary {
+			primaryType = ca.Type
+		} else {
+			writeVCardAddress(&b, ca.Type, false, ca.Address)
+		}
+	}
+	if a := c.Address; a != (Address{ID: a.ID}) {
+		writeVCardAddress(&b, primaryType, len(c.Addresses) > 1, a)
+		if a.Latitude != "" && a.Longitude != "" {
+			writeVCardLine(&b, fmt.Sprintf("GEO:geo:%s,%s", a.Latitude, a.Longitude))
+		}
+	}
+
+	if len(c.Tags) > 0 {
+		var names []string
+		for _, t := range c.Tags {
+			names = append(names, escapeVCard(t.Name))
+		}
+		writeVCardLine(&b, "CATEGORIES:"+strings.Join(names, ","))
+	}
+	for _, n := range c.Notes {
+		line := "NOTE"
+		if n.Author != "" {
+			line += ";X-AUTHOR=" + quoteVCardParam(n.Author)
+		}
+		if n.Date != nil {
+			line += ";X-DATE=" + n.Date.UTC().Format("20060102T150405Z")
+		}
+		writeVCardLine(&b, line+":"+escapeVCard(n.Content))
+	}
+
+	if c.LastChange != nil {
+		writeVCardLine(&b, "REV:"+c.LastChange.UTC().Format("20060102T150405Z"))
+	}
+	writeVCardLine(&b, "END:VCARD")
+
+	return b.String()
+}
+
+// vCardMethod returns the EMAIL or TEL line of a contact method
+func vCardMethod(m ContactMethod) string {
+	var (
+		line  = "TEL"
+		types []string
+	)
+
+	switch m.Kind {
+	case MethodEmail:
+		line = "EMAIL"
+	case MethodMobile:
+		types = append(types, "cell")
+	default:
+		types = append(types, "voice")
+	}
+	if m.Type == "home" || m.Type == "work" {
+		types = append([]string{m.Type}, types...)
+	}
+	if len(types) > 0 {
+		line += ";TYPE=" + strings.Join(types, ",")
+	}
+	if m.Primary {
+		line += ";PREF=1"
+	}
+
+	return line + ":" + escapeVCard(m.Value)
+}
+
+// writeVCardAddress writes the ADR line of an address
+func writeVCardAddress(b *strings.Builder, t string, pref bool, a Address) {
+	line := "ADR"
+	if t == "home" || t == "work" {
+		line += ";TYPE=" + t
+	}
+	if pref {
+		line += ";PREF=1"
+	}
+
+	street := strings.TrimSpace(a.HouseNumber + " " + a.Street)
+	writeVCardLine(b, line+":"+joinVCard("", a.Addition, street, a.City, a.State, a.PostalCode, a.Country))
+}
+
+// VCards serializes several contacts into a single vCard stream
+func VCards(contacts []Contact) string {
+	var b strings.Builder
+
+	for i := range contacts {
+		b.WriteString(contacts[i].VCard())
+	}
+
+	return b.String()
+}
+
+// ParseVCards reads every vCard of the stream and returns the matching contacts.
+// vCard 3.0 cards are accepted as well, since most phones still export them.
+func ParseVCards(data string) ([]Contact, error) {
+	var (
+		contacts []Contact
+		current  *Contact
+	)
+
+	for _, line := range unfoldVCard(data) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		name, params, value, err := splitVCardLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCARD"):
+			if current != nil {
+				return nil, errors.New("vcard: nested BEGIN:VCARD")
+			}
+			current = &Contact{}
+			continue
+		case name == "END" && strings.EqualFold(value, "VCARD"):
+			if current == nil {
+				return nil, errors.New("vcard: END:VCARD without BEGIN")
+			}
+			contacts = append(contacts, *current)
+			current = nil
+			continue
+		case current == nil:
+			return nil, fmt.Errorf("vcard: property %s outside of a card", name)
+		}
+
+		if err := current.setVCardProperty(name, params, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if current != nil {
+		return nil, errors.New("vcard: missing END:VCARD")
+	}
+
+	return contacts, nil
+}
+
+// setVCardProperty maps a single vCard property onto the contact
+func (c *Contact) setVCardProperty(name string, params map[string][]string, value string) error {
+	switch name {
+	case "UID":
+		if strings.HasPrefix(value, vCardUIDPrefix) {
+			if id, err := strconv.Atoi(strings.TrimPrefix(value, vCardUIDPrefix)); err == nil {
+				c.ID = uint(id)
+			}
+		}
+	case "N":
+		fields := splitVCard(value, ';')
+		c.Surname = fields[0]
+		if len(fields) > 1 {
+			c.Firstname = fields[1]
+		}
+	case "FN":
+		if c.Firstname == "" && c.Surname == "" {
+			fn := strings.Fields(unescapeVCard(value))
+			if len(fn) > 0 {
+				c.Firstname = fn[0]
+				c.Surname = strings.Join(fn[1:], " ")
+			}
+		}
+	case "X-MARRIED-NAME":
+		c.MarriedName = vCardString(unescapeVCard(value))
+	case "GENDER":
+		c.Gender = vCardString(splitVCard(value, ';')[0])
+	case "BDAY":
+		// phones export partial dates such as --0412 which can't be stored, skip them
+		if d, err := parseVCardDate(value); err == nil {
+			c.Birthdate = d
+		}
+	case "EMAIL":
+		c.addVCardMethod(MethodEmail, params, unescapeVCard(value))
+	case "TEL":
+		tel := strings.TrimPrefix(unescapeVCard(value), "tel:")
+		if hasVCardType(params, "cell") {
+			c.addVCardMethod(MethodMobile, params, tel)
+		} else {
+			c.addVCardMethod(MethodPhone, params, tel)
+		}
+	case "ADR":
+		fields := splitVCard(value, ';')
+		for len(fields) < 7 {
+			fields = append(fields, "")
+		}
+		var a Address
+		a.Addition = fields[1]
+		a.HouseNumber, a.Street = splitVCardStreet(fields[2])
+		a.City = fields[3]
+		a.State = fields[4]
+		a.PostalCode = fields[5]
+		a.Country = fields[6]
+
+		// the first or preferred address is the primary one, GEO applies to it
+		pref := len(c.Addresses) == 0 || hasVCardPref(params)
+		if pref {
+			for i := range c.Addresses {
+				c.Addresses[i].Primary = false
+			}
+			a.Latitude, a.Longitude = c.Address.Latitude, c.Address.Longitude
+			c.Address = a
+		}
+		c.Addresses = append(c.Addresses, ContactAddress{Type: vCardType(params), Primary: pref, Source: "vcard", Address: a})
+	case "GEO":
+		geo := strings.TrimPrefix(value, "geo:")
+		sep := ","
+		if !strings.Contains(geo, sep) {
+			sep = ";"
+		}
+		if coords := strings.SplitN(geo, sep, 2); len(coords) == 2 {
+			c.Address.Latitude = strings.TrimSpace(coords[0])
+			c.Address.Longitude = strings.TrimSpace(coords[1])
+			for i := range c.Addresses {
+				if c.Addresses[i].Primary {
+					c.Addresses[i].Address.Latitude, c.Addresses[i].Address.Longitude = c.Address.Latitude, c.Address.Longitude
+				}
+			}
+		}
+	case "CATEGORIES":
+		for _, name := range splitVCard(value, ',') {
+			if name = strings.TrimSpace(name); name != "" {
+				c.Tags = append(c.Tags, Tag{Name: name})
+			}
+		}
+	case "NOTE":
+		n := Note{Content: unescapeVCard(value)}
+		if author, ok := params["X-AUTHOR"]; ok {
+			n.Author = strings.Join(author, ",")
+		}
+		// as for BDAY and REV, a date which can't be read is skipped rather than failing the whole import
+		if date, ok := params["X-DATE"]; ok && len(date) > 0 {
+			if d, err := parseVCardDate(date[0]); err == nil {
+				n.Date = d
+			}
+		}
+		c.Notes = append(c.Notes, n)
+	case "REV":
+		if d, err := parseVCardDate(value); err == nil {
+			c.LastChange = d
+		}
+	}
+
+	return nil
+}
+
+// addVCardMethod adds an EMAIL or TEL property to the methods of the contact,
+// the first or preferred one of its kind filling the flat field as well
+func (c *Contact) addVCardMethod(kind string, params map[string][]string, value string) {
+	if value == "" {
+		return
+	}
+
+	pref := primaryMethod(c.Methods, kind) < 0 || hasVCardPref(params)
+	if pref {
+		for i := range c.Methods {
+			if c.Methods[i].Kind == kind {
+				c.Methods[i].Primary = false
+			}
+		}
+		switch kind {
+		case MethodEmail:
+			c.Mail = &value
+		case MethodPhone:
+			c.Phone = &value
+		case MethodMobile:
+			c.Mobile = &value
+		}
+	}
+
+	c.Methods = append(c.Methods, ContactMethod{Kind: kind, Type: vCardType(params), Value: value, Primary: pref, Source: "vcard"})
+}
+
+// vCardType returns home or work when the TYPE parameter says so
+func vCardType(params map[string][]string) string {
+	for _, t := range []string{"home", "work"} {
+		if hasVCardType(params, t) {
+			return t
+		}
+	}
+
+	return ""
+}
+
+// hasVCardPref reports whether the property is the preferred one, with PREF=1 in 4.0 or TYPE=pref in 3.0
+func hasVCardPref(params map[string][]string) bool {
+	for _, v := range params["PREF"] {
+		if v == "1" {
+			return true
+		}
+	}
+
+	return hasVCardType(params, "pref")
+}
+
+// writeVCardLine writes a content line, folded at 75 octets as required by the RFC
+func writeVCardLine(b *strings.Builder, line string) {
+	// continuation lines start with a space, which counts in their 75 octets
+	for limit := 75; len(line) > limit; limit = 74 {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+// unfoldVCard splits the stream into logical content lines
+func unfoldVCard(data string) []string {
+	var lines []string
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// splitVCardLine splits a content line into its upper-cased name, its parameters and its raw value
+func splitVCardLine(line string) (string, map[string][]string, string, error) {
+	var (
+		quoted bool
+		colon  = -1
+	)
+
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return "", nil, "", fmt.Errorf("vcard: malformed line %q", line)
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	name := strings.ToUpper(parts[0])
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+
+	params := make(map[string][]string)
+	for _, p := range parts[1:] {
+		key, value := "TYPE", p
+		if eq := strings.Index(p, "="); eq >= 0 {
+			key, value = strings.ToUpper(p[:eq]), p[eq+1:]
+		}
+		for _, v := range strings.Split(strings.Trim(value, `"`), ",") {
+			params[key] = append(params[key], v)
+		}
+	}
+
+	return name, params, line[colon+1:], nil
+}
+
+// hasVCardType reports whether the TYPE parameter contains the given value
+func hasVCardType(params map[string][]string, t string) bool {
+	for _, v := range params["TYPE"] {
+		if strings.EqualFold(v, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitVCardStreet separates a leading house number from the street name
+func splitVCardStreet(street string) (string, string) {
+	street = strings.TrimSpace(street)
+	i := strings.IndexByte(street, ' ')
+	if i <= 0 || street[0] < '0' || street[0] > '9' {
+		return "", street
+	}
+	return street[:i], strings.TrimSpace(street[i+1:])
+}
+
+// parseVCardDate parses the date and timestamp formats used by BDAY and REV
+func parseVCardDate(value string) (*time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "2006-01-02T15:04:05Z07:00", "20060102", "2006-01-02"} {
+		if d, err := time.Parse(layout, value); err == nil {
+			return &d, nil
+		}
+	}
+	return nil, fmt.Errorf("vcard: invalid date %q", value)
+}
+
+// joinVCard escapes the components of a structured value and joins them with semicolons
+func joinVCard(fields ...string) string {
+	for i := range fields {
+		fields[i] = escapeVCard(fields[i])
+	}
+	return strings.Join(fields, ";")
+}
+
+// splitVCard splits a structured value on the unescaped separators and unescapes its components
+func splitVCard(value string, sep byte) []string {
+	var (
+		fields  []string
+		current strings.Builder
+	)
+
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value):
+			current.WriteByte(value[i])
+			current.WriteByte(value[i+1])
+			i++
+		case value[i] == sep:
+			fields = append(fields, unescapeVCard(current.String()))
+			current.Reset()
+		default:
+			current.WriteByte(value[i])
+		}
+	}
+
+	return append(fields, unescapeVCard(current.String()))
+}
+
+func escapeVCard(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+func unescapeVCard(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\,`, ",", `\;`, ";", `\n`, "\n", `\N`, "\n").Replace(s)
+}
+
+func quoteVCardParam(s string) string {
+	return `"` + strings.Replace(s, `"`, "'", -1) + `"`
+}
+
+func vCardString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
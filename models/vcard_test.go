@@ -0,0 +1,170 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func vCardStr(s string) *string {
+	return &s
+}
+
+func vCardTime(t *testing.T, value string) *time.Time {
+	d, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &d
+}
+
+// roundTrip exports a contact and parses it back
+func roundTrip(t *testing.T, c Contact) Contact {
+	contacts, err := ParseVCards(c.VCard())
+	if err != nil {
+		t.Fatalf("parse: %v\n%s", err, c.VCard())
+	}
+	if len(contacts) != 1 {
+		t.Fatalf("parse: got %d contacts, want 1", len(contacts))
+	}
+	return contacts[0]
+}
+
+func TestVCardRoundTripNames(t *testing.T) {
+	c := roundTrip(t, Contact{ID: 42, Firstname: "Jean-Luc", Surname: "De La Tour; Dupont, fils", MarriedName: vCardStr("Martin"), Gender: vCardStr("M")})
+
+	if c.ID != 42 {
+		t.Errorf("ID = %d, want 42", c.ID)
+	}
+	if c.Firstname != "Jean-Luc" || c.Surname != "De La Tour; Dupont, fils" {
+		t.Errorf("name = %q %q", c.Firstname, c.Surname)
+	}
+	if c.MarriedName == nil || *c.MarriedName != "Martin" {
+		t.Errorf("married name = %v, want Martin", c.MarriedName)
+	}
+	if c.Gender == nil || *c.Gender != "M" {
+		t.Errorf("gender = %v, want M", c.Gender)
+	}
+}
+
+func TestVCardRoundTripMethods(t *testing.T) {
+	c := roundTrip(t, Contact{Methods: []ContactMethod{
+		{Kind: MethodEmail, Type: "work", Value: "jean@work.example", Primary: false},
+		{Kind: MethodEmail, Type: "home", Value: "jean@home.example", Primary: true},
+		{Kind: MethodPhone, Type: "home", Value: "+33 1 23 45 67 89", Primary: true},
+		{Kind: MethodMobile, Value: "+33 6 12 34 56 78", Primary: true},
+	}})
+
+	if len(c.Methods) != 4 {
+		t.Fatalf("got %d methods, want 4", len(c.Methods))
+	}
+	if c.Mail == nil || *c.Mail != "jean@home.example" {
+		t.Errorf("mail = %v, want the preferred email", c.Mail)
+	}
+	if c.Phone == nil || *c.Phone != "+33 1 23 45 67 89" {
+		t.Errorf("phone = %v", c.Phone)
+	}
+	if c.Mobile == nil || *c.Mobile != "+33 6 12 34 56 78" {
+		t.Errorf("mobile = %v", c.Mobile)
+	}
+	if m := c.Methods[0]; m.Kind != MethodEmail || m.Type != "work" || m.Primary {
+		t.Errorf("first method = %+v, want a secondary work email", m)
+	}
+}
+
+func TestVCardRoundTripFlatMethods(t *testing.T) {
+	c := roundTrip(t, Contact{Mail: vCardStr("jean@example.com"), Phone: vCardStr("0123456789"), Mobile: vCardStr("0612345678")})
+
+	if c.Mail == nil || *c.Mail != "jean@example.com" {
+		t.Errorf("mail = %v", c.Mail)
+	}
+	if c.Phone == nil || *c.Phone != "0123456789" {
+		t.Errorf("phone = %v", c.Phone)
+	}
+	if c.Mobile == nil || *c.Mobile != "0612345678" {
+		t.Errorf("mobile = %v", c.Mobile)
+	}
+}
+
+func TestVCardRoundTripBirthdate(t *testing.T) {
+	c := roundTrip(t, Contact{Birthdate: vCardTime(t, "1970-04-12T00:00:00Z")})
+
+	if c.Birthdate == nil || !c.Birthdate.Equal(time.Date(1970, 4, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("birthdate = %v, want 1970-04-12", c.Birthdate)
+	}
+}
+
+func TestVCardRoundTripAddresses(t *testing.T) {
+	primary := Address{HouseNumber: "12", Street: "rue de la Paix", Addition: "Bât. B, 2e étage", City: "Paris", PostalCode: "75002", Country: "France", Latitude: "48.8686", Longitude: "2.3314"}
+	other := Address{Street: "chemin des Vignes", City: "Lyon", PostalCode: "69001"}
+
+	c := roundTrip(t, Contact{
+		Address:   primary,
+		Addresses: []ContactAddress{{Type: "work", Address: other}, {Type: "home", Primary: true, Address: primary}},
+	})
+
+	if len(c.Addresses) != 2 {
+		t.Fatalf("got %d addresses, want 2", len(c.Addresses))
+	}
+	a := c.Address
+	if a.HouseNumber != "12" || a.Street != "rue de la Paix" || a.Addition != "Bât. B, 2e étage" || a.City != "Paris" || a.PostalCode != "75002" || a.Country != "France" {
+		t.Errorf("address = %+v", a)
+	}
+	if a.Latitude != "48.8686" || a.Longitude != "2.3314" {
+		t.Errorf("coordinates = %s,%s", a.Latitude, a.Longitude)
+	}
+	for _, ca := range c.Addresses {
+		switch ca.Type {
+		case "home":
+			if !ca.Primary || ca.Address.City != "Paris" {
+				t.Errorf("home address = %+v, want the primary one in Paris", ca)
+			}
+		case "work":
+			if ca.Primary || ca.Address.City != "Lyon" || ca.Address.Street != "chemin des Vignes" {
+				t.Errorf("work address = %+v", ca)
+			}
+		default:
+			t.Errorf("unexpected address type %q", ca.Type)
+		}
+	}
+}
+
+func TestVCardRoundTripNotesAndTags(t *testing.T) {
+	long := strings.Repeat("Très long commentaire, avec des virgules; ", 5) + "\nsur deux lignes"
+	c := roundTrip(t, Contact{
+		Notes: []Note{{Content: long, Author: "Marie \"M\" Curie", Date: vCardTime(t, "2016-03-01T10:30:00Z")}, {Content: "sans date"}},
+		Tags:  []Tag{{Name: "bénévole"}, {Name: "porte, à porte"}},
+	})
+
+	if len(c.Notes) != 2 {
+		t.Fatalf("got %d notes, want 2", len(c.Notes))
+	}
+	if n := c.Notes[0]; n.Content != long || n.Author != "Marie 'M' Curie" || n.Date == nil || !n.Date.Equal(time.Date(2016, 3, 1, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("first note = %+v", n)
+	}
+	if n := c.Notes[1]; n.Content != "sans date" || n.Author != "" || n.Date != nil {
+		t.Errorf("second note = %+v", n)
+	}
+
+	if len(c.Tags) != 2 || c.Tags[0].Name != "bénévole" || c.Tags[1].Name != "porte, à porte" {
+		t.Errorf("tags = %+v", c.Tags)
+	}
+}
+
+func TestParseVCardsSkipsInvalidNoteDate(t *testing.T) {
+	data := "BEGIN:VCARD\r\nVERSION:4.0\r\nN:Dupont;Jean;;;\r\nBDAY:--0412\r\nNOTE;X-DATE=hier:appelé\r\nREV:jamais\r\nEND:VCARD\r\n"
+
+	contacts, err := ParseVCards(data)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(contacts) != 1 || len(contacts[0].Notes) != 1 {
+		t.Fatalf("got %+v, want a contact with a note", contacts)
+	}
+	if n := contacts[0].Notes[0]; n.Content != "appelé" || n.Date != nil {
+		t.Errorf("note = %+v, want its content without date", n)
+	}
+	if contacts[0].Birthdate != nil || contacts[0].LastChange != nil {
+		t.Errorf("invalid BDAY and REV should be skipped")
+	}
+}
@@ -0,0 +1,61 @@
+// Definition of the structures and SQL interaction functions
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ConflictError is returned when an update was made from an outdated version of an entity,
+// it carries the current version on the server so that the client can fetch it and merge
+type ConflictError struct {
+	Entity  string
+	ID      uint
+	Version uint
+}
+
+const conflictFormat = "conflict: %s %d is at version %d"
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf(conflictFormat, e.Entity, e.ID, e.Version)
+}
+
+// AsConflict returns the conflict behind an error, net/rpc only carrying the message of the errors
+func AsConflict(err error) (*ConflictError, bool) {
+	if err == nil {
+		return nil, false
+	}
+	if e, ok := err.(*ConflictError); ok {
+		return e, true
+	}
+
+	var e ConflictError
+	if n, _ := fmt.Sscanf(err.Error(), conflictFormat, &e.Entity, &e.ID, &e.Version); n != 3 {
+		return nil, false
+	}
+
+	return &e, true
+}
+
+// bumpVersion moves the stored version of an entity one step forward, provided the client edited the current one
+func bumpVersion(db *gorm.DB, value interface{}, entity string, groupID uint, id uint, version uint) error {
+	res := db.Model(value).Where("group_id = ? AND id = ? AND version = ?", groupID, id, version).UpdateColumn("version", version+1)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 1 {
+		return nil
+	}
+
+	var versions []uint
+	if err := db.Model(value).Where("group_id = ? AND id = ?", groupID, id).Pluck("version", &versions).Error; err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return errors.New("save: " + entity + " not found")
+	}
+
+	return &ConflictError{Entity: entity, ID: id, Version: versions[0]}
+}
@@ -16,3 +16,9 @@ type Action struct {
 type ActionsJson struct {
 	ActionsJson models.ActionsJson `json:"actions_json"`
 }
+
+// ActionMatrix is a type used for JSON request responses
+type ActionMatrix struct {
+	Contacts []models.ActionContact `json:"contacts"`
+	Stats    *models.ActionStats    `json:"stats"`
+}
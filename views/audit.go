@@ -0,0 +1,9 @@
+// Views for JSON responses
+package views
+
+import "github.com/quorumsco/contacts/models"
+
+// AuditEntries is a type used for JSON request responses
+type AuditEntries struct {
+	AuditEntries []models.AuditEntry `json:"audit_entries"`
+}
@@ -0,0 +1,14 @@
+// Views for JSON responses
+package views
+
+import "github.com/quorumsco/contacts/models"
+
+// BulkJob is a type used for JSON request responses
+type BulkJob struct {
+	Job *models.BulkJob `json:"job"`
+}
+
+// BulkJobs is a type used for JSON request responses
+type BulkJobs struct {
+	Jobs []models.BulkJob `json:"jobs"`
+}
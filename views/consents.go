@@ -0,0 +1,14 @@
+// Views for JSON responses
+package views
+
+import "github.com/quorumsco/contacts/models"
+
+// Consent is a type used for JSON request responses
+type Consent struct {
+	Consent *models.Consent `json:"consent"`
+}
+
+// Consents is a type used for JSON request responses
+type Consents struct {
+	Consents []models.Consent `json:"consents"`
+}
@@ -16,3 +16,15 @@ type Fact struct {
 type FactsJson struct {
 	FactsJson models.FactsJson `json:"facts_json"`
 }
+
+// FactSearch is a type used for JSON request responses
+type FactSearch struct {
+	Facts     []models.Fact       `json:"facts"`
+	Total     int64               `json:"total"`
+	Histogram []models.FactBucket `json:"histogram"`
+}
+
+// Geofence is a type used for JSON request responses
+type Geofence struct {
+	Geofence *models.GeofenceReport `json:"geofence"`
+}
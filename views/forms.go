@@ -0,0 +1,19 @@
+// Views for JSON responses
+package views
+
+import "github.com/quorumsco/contacts/models"
+
+// Form is a type used for JSON request responses
+type Form struct {
+	Form *models.Form `json:"form"`
+}
+
+// Forms is a type used for JSON request responses
+type Forms struct {
+	Forms []models.Form `json:"forms"`
+}
+
+// FormRevisions is a type used for JSON request responses
+type FormRevisions struct {
+	Revisions []models.FormRevision `json:"revisions"`
+}
@@ -0,0 +1,15 @@
+// Views for JSON responses
+package views
+
+import "github.com/quorumsco/contacts/models"
+
+// SubjectAccess is a type used for JSON request responses
+type SubjectAccess struct {
+	SubjectAccess *models.SubjectAccess `json:"subject_access"`
+}
+
+// Erasure is a type used for JSON request responses
+type Erasure struct {
+	Erasure   *models.Erasure `json:"erasure"`
+	Remaining map[string]int  `json:"remaining"`
+}
@@ -0,0 +1,9 @@
+// Views for JSON responses
+package views
+
+import "github.com/quorumsco/contacts/models"
+
+// GroupSetting is a type used for JSON request responses
+type GroupSetting struct {
+	GroupSetting *models.GroupSetting `json:"group_setting"`
+}
@@ -0,0 +1,14 @@
+// Views for JSON responses
+package views
+
+import "github.com/quorumsco/contacts/models"
+
+// Lease is a type used for JSON request responses
+type Lease struct {
+	Lease *models.Lease `json:"lease"`
+}
+
+// Leases is a type used for JSON request responses
+type Leases struct {
+	Leases []models.Lease `json:"leases"`
+}
@@ -12,3 +12,18 @@ type Missions struct {
 type Mission struct {
 	Mission *models.Mission `json:"mission"`
 }
+
+// Route is a type used for JSON request responses
+type Route struct {
+	Route *models.Route `json:"route"`
+}
+
+// Partition is a type used for JSON request responses
+type Partition struct {
+	Partition *models.Partition `json:"partition"`
+}
+
+// MissionProgress is a type used for JSON request responses
+type MissionProgress struct {
+	Progress *models.MissionProgress `json:"progress"`
+}
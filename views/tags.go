@@ -12,3 +12,8 @@ type Tags struct {
 type Tag struct {
 	Tag *models.Tag `json:"tag"`
 }
+
+// TagUsage is a type used for JSON request responses
+type TagUsage struct {
+	Usage map[uint]int `json:"usage"`
+}
@@ -0,0 +1,10 @@
+// Views for JSON responses
+package views
+
+import "github.com/quorumsco/contacts/models"
+
+// Timeline is a type used for JSON request responses
+type Timeline struct {
+	Entries []models.TimelineEntry `json:"entries"`
+	Total   int                    `json:"total"`
+}
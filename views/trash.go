@@ -0,0 +1,9 @@
+// Views for JSON responses
+package views
+
+import "github.com/quorumsco/contacts/models"
+
+// Trash is a type used for JSON request responses
+type Trash struct {
+	Trash *models.Trash `json:"trash"`
+}
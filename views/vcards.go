@@ -0,0 +1,7 @@
+// Views for JSON responses
+package views
+
+// VCard is a type used for vCard responses
+type VCard struct {
+	Data string `json:"vcard"`
+}